@@ -0,0 +1,157 @@
+package account
+
+import (
+	"BinanceTopStrategies/config"
+	"BinanceTopStrategies/sdk"
+	"BinanceTopStrategies/sql"
+	"errors"
+	"fmt"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Account is one Binance account the tick trades on, fields other than the credentials default to the top level
+// config of the same name
+type Account struct {
+	Name               string
+	ApiKey             string  `env:"API_KEY" credential:"required"`
+	SecretKey          string  `env:"SECRET_KEY" credential:"required"`
+	CSRFToken          string  `db:"CSRF" credential:"required"`
+	Cookie             string  `db:"COOKIE" credential:"required"`
+	CookieTime         string  `db:"COOKIE_TIME" credential:"optional"`
+	Paper              bool    `env:"PAPER"`
+	Reserved           float64 `env:"RESERVED"`
+	MaxPerChunk        float64 `env:"MAX_PER_CHUNK"`
+	MaxUSDTChunks      int     `env:"MAX_USDT_CHUNKS"`
+	MaxUSDCChunks      int     `env:"MAX_USDC_CHUNKS"`
+	MaxNeutrals        int     `env:"MAX_NEUTRALS"`
+	PreferredLeverage  int     `env:"PREFERRED_LEVERAGE"`
+	MaxLeverage        int     `env:"MAX_LEVERAGE"`
+	BlacklistNamespace string  `env:"BLACKLIST_NAMESPACE"`
+	CookieTimeParsed   time.Time
+	Futures            *futures.Client
+}
+
+var Accounts []*Account
+
+// Init loads the accounts listed in ACCOUNTS, or the single top level account when there is none.
+// A named account reads its settings from <NAME>_<ENV> and its cookie from <NAME>_<KEY> in bts.config,
+// its credentials never fall back to the top level ones so it can't trade on the default wallet.
+func Init() error {
+	Accounts = nil
+	names := config.TheConfig.Accounts
+	if len(names) == 0 {
+		names = []string{""}
+	}
+	for _, name := range names {
+		a, err := load(strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+		Accounts = append(Accounts, a)
+	}
+	return nil
+}
+
+func load(name string) (*Account, error) {
+	a := &Account{Name: name}
+	c := reflect.ValueOf(config.TheConfig).Elem()
+	v := reflect.ValueOf(a).Elem()
+	t := v.Type()
+	missing := make([]string, 0)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		credential := field.Tag.Get("credential")
+		if def := c.FieldByName(field.Name); def.IsValid() && def.Type() == field.Type && (name == "" || credential == "") {
+			v.Field(i).Set(def)
+		}
+		if name == "" {
+			continue
+		}
+		if tag := field.Tag.Get("env"); tag != "" {
+			if s, ok := os.LookupEnv(prefix(name) + tag); ok {
+				err := setString(v.Field(i), s)
+				if err != nil {
+					return nil, fmt.Errorf("error parsing %s%s: %w", prefix(name), tag, err)
+				}
+			}
+			if credential == "required" && v.Field(i).String() == "" {
+				missing = append(missing, prefix(name)+tag)
+			}
+		}
+		if tag := field.Tag.Get("db"); tag != "" {
+			var s string
+			err := sql.GetDB().ScanOne(&s, `SELECT value FROM bts.config WHERE key = $1`, prefix(name)+tag)
+			if err == nil {
+				v.Field(i).SetString(strings.ReplaceAll(s, "\n", ""))
+			} else if !errors.Is(err, pgx.ErrNoRows) {
+				return nil, fmt.Errorf("error reading %s%s: %w", prefix(name), tag, err)
+			}
+			if credential == "required" && v.Field(i).String() == "" {
+				missing = append(missing, prefix(name)+tag)
+			} else if err != nil {
+				log.Warnf("No %s%s in config", prefix(name), tag)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("account %s has no %s", name, strings.Join(missing, ", "))
+	}
+	if name != "" && a.BlacklistNamespace == "" {
+		a.BlacklistNamespace = name
+	}
+	if a.CookieTime != "" {
+		i, err := strconv.ParseInt(a.CookieTime, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		a.CookieTimeParsed = time.Unix(i, 0)
+	}
+	a.Futures = sdk.NewFuturesClient(a.ApiKey, a.SecretKey)
+	return a, nil
+}
+
+func prefix(name string) string {
+	return strings.ToUpper(name) + "_"
+}
+
+func setString(field reflect.Value, s string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(i))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported kind %s", field.Kind())
+	}
+	return nil
+}
+
+func (a *Account) String() string {
+	if a.Name == "" {
+		return "default"
+	}
+	return a.Name
+}
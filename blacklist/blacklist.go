@@ -1,6 +1,7 @@
 package blacklist
 
 import (
+	"BinanceTopStrategies/clock"
 	"BinanceTopStrategies/discord"
 	"BinanceTopStrategies/sql"
 	"context"
@@ -12,7 +13,7 @@ import (
 
 func writeKey(key string, d time.Duration, reason string) {
 	err := sql.SimpleTransaction(func(tx pgx.Tx) error {
-		till := time.Now().Add(d)
+		till := clock.Now().Add(d)
 		_, err := tx.Exec(context.Background(),
 			`INSERT INTO bts.blacklist (key, till, reason) VALUES ($1, $2, $3) ON CONFLICT (key) DO UPDATE
 SET till = EXCLUDED.till,
@@ -28,21 +29,37 @@ WHERE bts.blacklist.till < EXCLUDED.till;`,
 
 const (
 	GLOBAL = "global_block"
+	HALT   = "trading_halt" // every account, not namespaced
 )
 
-func BlockTrading(d time.Duration, reason string) {
-	writeKey(GLOBAL, d, reason)
-	discord.Blacklistf(fmt.Sprintf("**Global block:** %s, %s", d, reason))
+// namespaced keeps the keys of each account apart, the empty namespace is the original single account one
+func namespaced(namespace, key string) string {
+	if namespace == "" {
+		return key
+	}
+	return namespace + ":" + key
+}
+
+func display(namespace string) string {
+	if namespace == "" {
+		return ""
+	}
+	return "[" + namespace + "] "
+}
+
+func BlockTrading(namespace string, d time.Duration, reason string) {
+	writeKey(namespaced(namespace, GLOBAL), d, reason)
+	discord.Blacklistf(fmt.Sprintf("%s**Global block:** %s, %s", display(namespace), d, reason))
 }
 
-func AddSymbolDirection(symbol, direction string, d time.Duration, reason string) {
-	writeKey(symbol+direction, d, reason)
-	discord.Blacklistf(fmt.Sprintf("**Add blacklist:** %s, %s, %s, %s", symbol, direction, d, reason))
+func AddSymbolDirection(namespace, symbol, direction string, d time.Duration, reason string) {
+	writeKey(namespaced(namespace, symbol+direction), d, reason)
+	discord.Blacklistf(fmt.Sprintf("%s**Add blacklist:** %s, %s, %s, %s", display(namespace), symbol, direction, d, reason))
 }
 
-func AddSymbol(symbol string, d time.Duration, reason string) {
-	writeKey(symbol, d, reason)
-	discord.Blacklistf(fmt.Sprintf("**Add blacklist:** %s, %s, %s", symbol, d, reason))
+func AddSymbol(namespace, symbol string, d time.Duration, reason string) {
+	writeKey(namespaced(namespace, symbol), d, reason)
+	discord.Blacklistf(fmt.Sprintf("%s**Add blacklist:** %s, %s, %s", display(namespace), symbol, d, reason))
 }
 
 type TillStruct struct {
@@ -51,17 +68,59 @@ type TillStruct struct {
 	Reason string    `db:"reason"`
 }
 
-func IsTradingBlocked(symbol, direction string) (bool, time.Time) {
+func IsTradingBlocked(namespace, symbol, direction string) (bool, time.Time) {
 	till := make([]TillStruct, 0)
 	err := sql.GetDB().Scan(&till, "SELECT * FROM bts.blacklist WHERE key=$1 OR key=$2 OR key=$3",
-		symbol+direction, symbol, GLOBAL)
+		namespaced(namespace, symbol+direction), namespaced(namespace, symbol), namespaced(namespace, GLOBAL))
 	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
 		discord.Errorf("Error scanning blacklist: %v", err)
 	}
 	for _, t := range till {
-		if t.Till.After(time.Now()) {
+		if t.Till.After(clock.Now()) {
 			return true, t.Till
 		}
 	}
 	return false, time.Time{}
 }
+
+// Cooldown tells if key is free, then holds it for d, so what is reported on every tick is reported once per d
+func Cooldown(namespace, key string, d time.Duration, reason string) bool {
+	now := clock.Now()
+	held := ""
+	err := sql.GetDB().ScanOne(&held,
+		`INSERT INTO bts.blacklist (key, till, reason) VALUES ($1, $2, $3) ON CONFLICT (key) DO UPDATE
+SET till = EXCLUDED.till,
+    reason = EXCLUDED.reason
+WHERE bts.blacklist.till < $4
+RETURNING key;`,
+		namespaced(namespace, "cooldown:"+key), now.Add(d), reason, now)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		discord.Errorf("Error writing cooldown %s: %v", key, err)
+	}
+	return true
+}
+
+// HaltTrading stops placing grids on every account for d, cancels still run
+func HaltTrading(d time.Duration, reason string) {
+	writeKey(HALT, d, reason)
+	discord.Blacklistf(fmt.Sprintf("**Trading halted:** %s, %s", d, reason))
+}
+
+// TradingHalted returns the reason of a halt that is still active
+func TradingHalted() (bool, string) {
+	till := TillStruct{}
+	err := sql.GetDB().ScanOne(&till, "SELECT * FROM bts.blacklist WHERE key=$1", HALT)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			discord.Errorf("Error scanning trading halt: %v", err)
+		}
+		return false, ""
+	}
+	if till.Till.After(clock.Now()) {
+		return true, till.Reason
+	}
+	return false, ""
+}
@@ -0,0 +1,200 @@
+package cache
+
+import (
+	"BinanceTopStrategies/clock"
+	"BinanceTopStrategies/config"
+	"container/list"
+	"context"
+	"fmt"
+	"github.com/redis/rueidis"
+	"sync"
+	"time"
+)
+
+// Backend stores serialized cache entries, the memory backend is per process
+// while the redis backend is shared by the trading and SQL processes
+type Backend interface {
+	Get(key string) ([]byte, bool, error)
+	Set(key string, value []byte, ttl time.Duration) error
+	Delete(key string) error
+}
+
+// valueBackend keeps entries as they are, caches skip serialization on a backend that is one
+type valueBackend interface {
+	GetValue(key string) (any, bool)
+	SetValue(key string, value any, ttl time.Duration)
+}
+
+var (
+	backendMutex   sync.Mutex
+	defaultBackend Backend = NewMemory(50000, nil)
+)
+
+// Init picks the backend of every cache from the config
+func Init() error {
+	var backend Backend
+	switch config.TheConfig.CacheBackend {
+	case "memory":
+		backend = NewMemory(config.TheConfig.CacheMemorySize, nil)
+	case "redis":
+		redis, err := NewRedis(config.TheConfig.CacheRedisAddr)
+		if err != nil {
+			return err
+		}
+		backend = redis
+	default:
+		return fmt.Errorf("unknown cache backend %s", config.TheConfig.CacheBackend)
+	}
+	SetBackend(backend)
+	return nil
+}
+
+func SetBackend(backend Backend) {
+	backendMutex.Lock()
+	defer backendMutex.Unlock()
+	defaultBackend = backend
+}
+
+// Shared tells if the configured backend is seen by the other processes, so Invalidate reaches them
+func Shared() bool {
+	_, ok := getBackend().(*redis)
+	return ok
+}
+
+func getBackend() Backend {
+	backendMutex.Lock()
+	defer backendMutex.Unlock()
+	return defaultBackend
+}
+
+type memoryEntry struct {
+	key       string
+	value     any
+	expiresAt time.Time
+}
+
+// memory is a LRU holding at most size entries, typed values are kept without serialization
+type memory struct {
+	mutex   sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+	clock   clock.Clock
+}
+
+// NewMemory expires entries on c, nil for the package clock
+func NewMemory(size int, c clock.Clock) Backend {
+	return &memory{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		clock:   c,
+	}
+}
+
+func (m *memory) now() time.Time {
+	if m.clock != nil {
+		return m.clock.Now()
+	}
+	return clock.Now()
+}
+
+func (m *memory) GetValue(key string) (any, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*memoryEntry)
+	if !entry.expiresAt.IsZero() && m.now().After(entry.expiresAt) {
+		m.order.Remove(e)
+		delete(m.entries, key)
+		return nil, false
+	}
+	m.order.MoveToFront(e)
+	return entry.value, true
+}
+
+func (m *memory) SetValue(key string, value any, ttl time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	entry := &memoryEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expiresAt = m.now().Add(ttl)
+	}
+	if e, ok := m.entries[key]; ok {
+		e.Value = entry
+		m.order.MoveToFront(e)
+		return
+	}
+	m.entries[key] = m.order.PushFront(entry)
+	for m.size > 0 && m.order.Len() > m.size {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryEntry).key)
+	}
+}
+
+func (m *memory) Get(key string) ([]byte, bool, error) {
+	value, ok := m.GetValue(key)
+	if !ok {
+		return nil, false, nil
+	}
+	raw, ok := value.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("cache %s holds a %T, not bytes", key, value)
+	}
+	return raw, true, nil
+}
+
+func (m *memory) Set(key string, value []byte, ttl time.Duration) error {
+	m.SetValue(key, value, ttl)
+	return nil
+}
+
+func (m *memory) Delete(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if e, ok := m.entries[key]; ok {
+		m.order.Remove(e)
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+type redis struct {
+	client rueidis.Client
+}
+
+func NewRedis(addr string) (Backend, error) {
+	client, err := rueidis.NewClient(rueidis.ClientOption{InitAddress: []string{addr}, DisableCache: true})
+	if err != nil {
+		return nil, err
+	}
+	return &redis{client: client}, nil
+}
+
+func (r *redis) Get(key string) ([]byte, bool, error) {
+	value, err := r.client.Do(context.Background(), r.client.B().Get().Key(key).Build()).AsBytes()
+	if rueidis.IsRedisNil(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (r *redis) Set(key string, value []byte, ttl time.Duration) error {
+	if ttl > 0 {
+		return r.client.Do(context.Background(),
+			r.client.B().Set().Key(key).Value(rueidis.BinaryString(value)).Px(ttl).Build()).Error()
+	}
+	return r.client.Do(context.Background(),
+		r.client.B().Set().Key(key).Value(rueidis.BinaryString(value)).Build()).Error()
+}
+
+func (r *redis) Delete(key string) error {
+	return r.client.Do(context.Background(), r.client.B().Del().Key(key).Build()).Error()
+}
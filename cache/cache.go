@@ -1,73 +1,302 @@
 package cache
 
 import (
+	"BinanceTopStrategies/clock"
+	"BinanceTopStrategies/config"
 	"BinanceTopStrategies/discord"
+	"encoding/json"
+	"golang.org/x/sync/singleflight"
+	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// DefaultRetention is how long the backend keeps map cache entries only HasExpired expires, unless Retention is set
+const DefaultRetention = 24 * time.Hour
+
+// Cache holds a single value under Name in the backend, as json unless the backend keeps values
 type Cache[T any] struct {
-	Data        T
-	LastFetched time.Time
+	Name        string
 	TTL         time.Duration
+	MaxStale    time.Duration // past expiry, how long the old value is served while refreshing in background
 	FetchMethod func() (T, error)
-	mutex       sync.Mutex
+	Backend     Backend // nil for the configured one
+	group       singleflight.Group
+	refreshing  atomic.Bool
 }
 
+// MapCache entries expire by HasExpired and/or TTL, whichever comes first
 type MapCache[T any] struct {
-	mutex       sync.Mutex
-	Data        map[string]T
+	Name        string
+	TTL         time.Duration
+	MaxStale    time.Duration
 	FetchMethod func(key string) (T, error)
 	HasExpired  func(value T) bool
+	Retention   time.Duration // how long the backend keeps entries without a TTL, DefaultRetention when zero
+	Backend     Backend       // nil for the configured one
+	group       singleflight.Group
+	refreshing  sync.Map // keys refreshed in background
 }
 
-func (c *MapCache[T]) Get(key string) (T, error) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	_, ok := c.Data[key]
-	if !ok || c.HasExpired(c.Data[key]) {
-		log.Debugf("Cache expired %s, fetching new data", key)
+type entry[T any] struct {
+	Data        T         `json:"data"`
+	LastFetched time.Time `json:"lastFetched"`
+	ExpiresAt   time.Time `json:"expiresAt"` // zero when only HasExpired decides
+}
+
+func backendOf(b Backend) Backend {
+	if b != nil {
+		return b
+	}
+	return getBackend()
+}
+
+// jitter spreads the expiry of entries fetched together, so they don't all refresh on the same tick
+func jitter(ttl time.Duration) time.Duration {
+	j := config.TheConfig.CacheTTLJitter
+	if ttl <= 0 || j <= 0 {
+		return ttl
+	}
+	return time.Duration(float64(ttl) * (1 + j*(2*rand.Float64()-1)))
+}
+
+func newEntry[T any](data T, ttl time.Duration) *entry[T] {
+	e := &entry[T]{Data: data, LastFetched: clock.Now()}
+	if ttl > 0 {
+		e.ExpiresAt = e.LastFetched.Add(jitter(ttl))
+	}
+	return e
+}
+
+func (e *entry[T]) expired(hasExpired func(value T) bool) bool {
+	if !e.ExpiresAt.IsZero() && clock.Now().After(e.ExpiresAt) {
+		return true
+	}
+	return hasExpired != nil && hasExpired(e.Data)
+}
+
+// servable tells if an expired entry may still be served while it is refreshed
+func (e *entry[T]) servable(maxStale time.Duration) bool {
+	return maxStale > 0 && clock.Since(e.LastFetched) < maxStale
+}
+
+// retention is how long the backend has to keep e for it to be served, zero when it has no TTL
+func (e *entry[T]) retention(maxStale time.Duration) time.Duration {
+	if e.ExpiresAt.IsZero() {
+		return 0
+	}
+	return max(e.ExpiresAt.Sub(e.LastFetched), maxStale)
+}
+
+func load[T any](backend Backend, key string) (*entry[T], bool) {
+	if values, ok := backend.(valueBackend); ok {
+		value, ok := values.GetValue(key)
+		if !ok {
+			return nil, false
+		}
+		e, ok := value.(*entry[T])
+		if !ok {
+			log.Errorf("Error decoding cache %s: %T", key, value)
+		}
+		return e, ok
+	}
+	raw, ok, err := backend.Get(key)
+	if err != nil {
+		log.Errorf("Error reading cache %s: %v", key, err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	e := &entry[T]{}
+	err = json.Unmarshal(raw, e)
+	if err != nil {
+		log.Errorf("Error decoding cache %s: %v", key, err)
+		return nil, false
+	}
+	return e, true
+}
+
+func store[T any](backend Backend, key string, e *entry[T], ttl time.Duration) {
+	if values, ok := backend.(valueBackend); ok {
+		values.SetValue(key, e, ttl)
+		return
+	}
+	raw, err := json.Marshal(e)
+	if err == nil {
+		err = backend.Set(key, raw, ttl)
+	}
+	if err != nil {
+		log.Errorf("Error writing cache %s: %v", key, err)
+	}
+}
+
+func (c *MapCache[T]) fullKey(key string) string {
+	return c.Name + ":" + key
+}
+
+// refresh fetches key once however many callers ask for it at the same time
+func (c *MapCache[T]) refresh(backend Backend, key string) (T, error) {
+	data, err, _ := c.group.Do(c.fullKey(key), func() (interface{}, error) {
+		log.Debugf("Cache expired %s, fetching new data", c.fullKey(key))
 		data, err := c.FetchMethod(key)
 		if err != nil {
-			discord.Errorf("Error fetching data: %v", err)
-			return c.Data[key], err
+			return data, err
 		}
-		c.Data[key] = data
+		e := newEntry(data, c.TTL)
+		store(backend, c.fullKey(key), e, c.retention(e))
+		return data, nil
+	})
+	if err != nil {
+		var zero T
+		return zero, err
 	}
-	return c.Data[key], nil
+	return data.(T), nil
 }
 
-func (c *Cache[T]) Get() (T, error) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-	if c.LastFetched.Add(c.TTL).Before(time.Now()) {
-		log.Debugf("Cache expired, fetching new data")
+func (c *MapCache[T]) retention(e *entry[T]) time.Duration {
+	if retention := e.retention(c.MaxStale); retention > 0 {
+		return retention
+	}
+	if c.Retention > 0 {
+		return c.Retention
+	}
+	return DefaultRetention
+}
+
+func (c *MapCache[T]) Get(key string) (T, error) {
+	backend := backendOf(c.Backend)
+	cached, ok := load[T](backend, c.fullKey(key))
+	if ok && !cached.expired(c.HasExpired) {
+		return cached.Data, nil
+	}
+	if ok && cached.servable(c.MaxStale) {
+		if _, busy := c.refreshing.LoadOrStore(key, struct{}{}); !busy {
+			go func() {
+				defer c.refreshing.Delete(key)
+				_, err := c.refresh(backend, key)
+				if err != nil {
+					discord.Errorf("Error refreshing %s: %v", c.fullKey(key), err)
+				}
+			}()
+		}
+		return cached.Data, nil
+	}
+	data, err := c.refresh(backend, key)
+	if err != nil {
+		discord.Errorf("Error fetching data: %v", err)
+		if ok {
+			return cached.Data, err
+		}
+		return data, err
+	}
+	return data, nil
+}
+
+// Prefetch warms the keys that are missing or expired, fetching up to concurrency of them at once
+func (c *MapCache[T]) Prefetch(keys []string, concurrency int) {
+	backend := backendOf(c.Backend)
+	sem := make(chan struct{}, max(1, concurrency))
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		cached, ok := load[T](backend, c.fullKey(key))
+		if ok && !cached.expired(c.HasExpired) {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(key string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			_, err := c.refresh(backend, key)
+			if err != nil {
+				log.Errorf("Error prefetching %s: %v", c.fullKey(key), err)
+			}
+		}(key)
+	}
+	wg.Wait()
+}
+
+// Invalidate drops key, the next Get fetches it again. Other processes only see it with a Shared backend
+func (c *MapCache[T]) Invalidate(key string) {
+	err := backendOf(c.Backend).Delete(c.fullKey(key))
+	if err != nil {
+		log.Errorf("Error invalidating cache %s: %v", c.fullKey(key), err)
+	}
+}
+
+func (c *Cache[T]) refresh(backend Backend) (T, error) {
+	data, err, _ := c.group.Do(c.Name, func() (interface{}, error) {
+		log.Debugf("Cache expired %s, fetching new data", c.Name)
 		data, err := c.FetchMethod()
 		if err != nil {
-			return c.Data, err
+			return data, err
 		}
-		c.Data = data
-		c.LastFetched = time.Now()
+		e := newEntry(data, c.TTL)
+		store(backend, c.Name, e, e.retention(c.MaxStale))
+		return data, nil
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return data.(T), nil
+}
+
+func (c *Cache[T]) Get() (T, error) {
+	backend := backendOf(c.Backend)
+	cached, ok := load[T](backend, c.Name)
+	if ok && !cached.expired(nil) {
+		return cached.Data, nil
+	}
+	if ok && cached.servable(c.MaxStale) {
+		if c.refreshing.CompareAndSwap(false, true) {
+			go func() {
+				defer c.refreshing.Store(false)
+				_, err := c.refresh(backend)
+				if err != nil {
+					discord.Errorf("Error refreshing %s: %v", c.Name, err)
+				}
+			}()
+		}
+		return cached.Data, nil
+	}
+	data, err := c.refresh(backend)
+	if err != nil {
+		if ok {
+			return cached.Data, err
+		}
+		return data, err
+	}
+	return data, nil
+}
+
+// Invalidate drops the cached value, the next Get fetches it again. Other processes only see it with a Shared backend
+func (c *Cache[T]) Invalidate() {
+	err := backendOf(c.Backend).Delete(c.Name)
+	if err != nil {
+		log.Errorf("Error invalidating cache %s: %v", c.Name, err)
 	}
-	return c.Data, nil
 }
 
-func CreateCache[T any](ttl time.Duration, fetchMethod func() (T, error)) *Cache[T] {
+func CreateCache[T any](name string, ttl time.Duration, fetchMethod func() (T, error)) *Cache[T] {
 	cache := &Cache[T]{
+		Name:        name,
 		TTL:         ttl,
 		FetchMethod: fetchMethod,
 	}
 	return cache
 }
 
-func CreateMapCache[T any](fetchMethod func(key string) (T, error),
+func CreateMapCache[T any](name string, fetchMethod func(key string) (T, error),
 	hasExpired func(value T) bool) *MapCache[T] {
 	cache := &MapCache[T]{
+		Name:        name,
 		FetchMethod: fetchMethod,
 		HasExpired:  hasExpired,
-		Data:        make(map[string]T),
 	}
 	return cache
 }
@@ -0,0 +1,344 @@
+package cache
+
+import (
+	"BinanceTopStrategies/clock"
+	"BinanceTopStrategies/config"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testBackend struct {
+	name    string
+	backend Backend
+	advance func(d time.Duration) // moves the time the backend expires entries on
+}
+
+// testBackends returns a memory and a redis backend, with the package clock set to a fake one
+func testBackends(t *testing.T) (*clock.Fake, []testBackend) {
+	config.Init()
+	config.TheConfig.CacheTTLJitter = 0
+	fake := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	clock.Set(fake)
+	t.Cleanup(func() { clock.Set(clock.Real{}) })
+	mr := miniredis.RunT(t)
+	redis, err := NewRedis(mr.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, []testBackend{
+		{name: "memory", backend: NewMemory(100, fake), advance: func(d time.Duration) { fake.Advance(d) }},
+		{name: "redis", backend: redis, advance: func(d time.Duration) {
+			fake.Advance(d)
+			mr.FastForward(d)
+		}},
+	}
+}
+
+func TestBackendSetGetDelete(t *testing.T) {
+	_, backends := testBackends(t)
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			err := b.backend.Set("k", []byte("v"), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, ok, err := b.backend.Get("k")
+			if err != nil || !ok || string(v) != "v" {
+				t.Fatalf("got %q %v %v, want v", v, ok, err)
+			}
+			err = b.backend.Delete("k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, ok, err = b.backend.Get("k")
+			if err != nil || ok {
+				t.Fatalf("got %v %v after delete, want a miss", ok, err)
+			}
+		})
+	}
+}
+
+func TestBackendExpiry(t *testing.T) {
+	_, backends := testBackends(t)
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			err := b.backend.Set("k", []byte("v"), time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.advance(59 * time.Second)
+			if _, ok, _ := b.backend.Get("k"); !ok {
+				t.Fatal("expired before its ttl")
+			}
+			b.advance(2 * time.Second)
+			if _, ok, _ := b.backend.Get("k"); ok {
+				t.Fatal("not expired after its ttl")
+			}
+		})
+	}
+}
+
+func TestMemoryEviction(t *testing.T) {
+	m := NewMemory(2, nil)
+	_ = m.Set("a", []byte("a"), 0)
+	_ = m.Set("b", []byte("b"), 0)
+	_, _, _ = m.Get("a") // b is now the least recently used
+	_ = m.Set("c", []byte("c"), 0)
+	if _, ok, _ := m.Get("b"); ok {
+		t.Fatal("least recently used entry not evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, ok, _ := m.Get(k); !ok {
+			t.Fatalf("%s evicted", k)
+		}
+	}
+}
+
+type sample struct {
+	Values map[string]float64
+}
+
+func TestMapCacheExpiry(t *testing.T) {
+	_, backends := testBackends(t)
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			var fetches atomic.Int32
+			c := &MapCache[*sample]{
+				Name:    "expiry",
+				TTL:     time.Minute,
+				Backend: b.backend,
+				FetchMethod: func(key string) (*sample, error) {
+					fetches.Add(1)
+					return &sample{Values: map[string]float64{key: float64(fetches.Load())}}, nil
+				},
+			}
+			for i := 0; i < 3; i++ {
+				v, err := c.Get("k")
+				if err != nil || v.Values["k"] != 1 {
+					t.Fatalf("got %v %v, want the first fetch", v, err)
+				}
+			}
+			b.advance(2 * time.Minute)
+			v, err := c.Get("k")
+			if err != nil || v.Values["k"] != 2 {
+				t.Fatalf("got %v %v after expiry, want a second fetch", v, err)
+			}
+		})
+	}
+}
+
+func TestMapCacheInvalidate(t *testing.T) {
+	_, backends := testBackends(t)
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			var fetches atomic.Int32
+			c := &MapCache[int]{
+				Name:    "invalidate",
+				Backend: b.backend,
+				FetchMethod: func(string) (int, error) {
+					return int(fetches.Add(1)), nil
+				},
+			}
+			_, _ = c.Get("k")
+			c.Invalidate("k")
+			v, err := c.Get("k")
+			if err != nil || v != 2 {
+				t.Fatalf("got %d %v after invalidate, want a second fetch", v, err)
+			}
+		})
+	}
+}
+
+func TestMapCacheSingleFlight(t *testing.T) {
+	_, backends := testBackends(t)
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			var fetches atomic.Int32
+			release := make(chan struct{})
+			c := &MapCache[int]{
+				Name:    "single_flight",
+				Backend: b.backend,
+				FetchMethod: func(string) (int, error) {
+					fetches.Add(1)
+					<-release
+					return 1, nil
+				},
+			}
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, _ = c.Get("k")
+				}()
+			}
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+			if n := fetches.Load(); n != 1 {
+				t.Fatalf("fetched %d times, want 1", n)
+			}
+		})
+	}
+}
+
+func TestMemoryKeepsTypedValues(t *testing.T) {
+	_, backends := testBackends(t)
+	c := &Cache[*sample]{
+		Name:    "typed",
+		TTL:     time.Minute,
+		Backend: backends[0].backend,
+		FetchMethod: func() (*sample, error) {
+			return &sample{Values: map[string]float64{"a": 1}}, nil
+		},
+	}
+	first, _ := c.Get()
+	second, _ := c.Get()
+	if first != second {
+		t.Fatal("memory backend decoded the entry again")
+	}
+}
+
+// nanSample carries a NaN the way gsp.WL does, json has no NaN
+type nanSample struct {
+	Ratio float64
+}
+
+func (s nanSample) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(s.Ratio) {
+		return []byte(`{"nan":true}`), nil
+	}
+	return []byte(fmt.Sprintf(`{"ratio":%v}`, s.Ratio)), nil
+}
+
+func (s *nanSample) UnmarshalJSON(data []byte) error {
+	if string(data) == `{"nan":true}` {
+		s.Ratio = math.NaN()
+		return nil
+	}
+	_, err := fmt.Sscanf(string(data), `{"ratio":%g}`, &s.Ratio)
+	return err
+}
+
+func TestNaNRoundTrip(t *testing.T) {
+	_, backends := testBackends(t)
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			var fetches atomic.Int32
+			c := &MapCache[nanSample]{
+				Name:    "nan",
+				TTL:     time.Minute,
+				Backend: b.backend,
+				FetchMethod: func(string) (nanSample, error) {
+					fetches.Add(1)
+					return nanSample{Ratio: math.NaN()}, nil
+				},
+			}
+			_, _ = c.Get("k")
+			v, err := c.Get("k")
+			if err != nil || !math.IsNaN(v.Ratio) {
+				t.Fatalf("got %v %v, want NaN", v, err)
+			}
+			if n := fetches.Load(); n != 1 {
+				t.Fatalf("fetched %d times, the NaN entry was not stored", n)
+			}
+		})
+	}
+}
+
+func TestMapCacheRetention(t *testing.T) {
+	testBackends(t)
+	mr := miniredis.RunT(t)
+	redis, err := NewRedis(mr.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiring := &MapCache[int]{
+		Name:        "retention_ttl",
+		TTL:         time.Minute,
+		MaxStale:    time.Hour,
+		Backend:     redis,
+		FetchMethod: func(string) (int, error) { return 1, nil },
+	}
+	unbounded := &MapCache[int]{
+		Name:        "retention_has_expired",
+		Retention:   10 * time.Minute,
+		HasExpired:  func(int) bool { return false },
+		Backend:     redis,
+		FetchMethod: func(string) (int, error) { return 1, nil },
+	}
+	_, _ = expiring.Get("k")
+	_, _ = unbounded.Get("k")
+	if ttl := mr.TTL("retention_ttl:k"); ttl != time.Hour {
+		t.Fatalf("kept for %v, want the max stale", ttl)
+	}
+	if ttl := mr.TTL("retention_has_expired:k"); ttl != 10*time.Minute {
+		t.Fatalf("kept for %v, want the retention", ttl)
+	}
+}
+
+func TestMapCacheStaleRefreshOnce(t *testing.T) {
+	_, backends := testBackends(t)
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			var fetches atomic.Int32
+			release := make(chan struct{})
+			c := &MapCache[int]{
+				Name:     "stale",
+				TTL:      time.Minute,
+				MaxStale: time.Hour,
+				Backend:  b.backend,
+				FetchMethod: func(string) (int, error) {
+					n := int(fetches.Add(1))
+					if n > 1 {
+						<-release
+					}
+					return n, nil
+				},
+			}
+			_, _ = c.Get("k")
+			b.advance(2 * time.Minute)
+			goroutines := runtime.NumGoroutine()
+			for i := 0; i < 100; i++ {
+				v, err := c.Get("k")
+				if err != nil || v != 1 {
+					t.Fatalf("got %d %v, want the stale value", v, err)
+				}
+			}
+			if n := runtime.NumGoroutine() - goroutines; n > 1 {
+				t.Fatalf("%d refreshes spawned, want 1", n)
+			}
+			close(release)
+			for i := 0; i < 100; i++ {
+				if v, _ := c.Get("k"); v == 2 {
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			t.Fatal("stale value never refreshed")
+		})
+	}
+}
+
+func TestShared(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redis, err := NewRedis(mr.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetBackend(NewMemory(50000, nil)) })
+	SetBackend(NewMemory(10, nil))
+	if Shared() {
+		t.Fatal("memory backend reported shared")
+	}
+	SetBackend(redis)
+	if !Shared() {
+		t.Fatal("redis backend not reported shared")
+	}
+}
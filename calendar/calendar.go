@@ -0,0 +1,183 @@
+package calendar
+
+import (
+	"BinanceTopStrategies/clock"
+	"BinanceTopStrategies/config"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Window struct {
+	From int // minute of hour, inclusive
+	To   int // minute of hour, inclusive
+}
+
+type Calendar struct {
+	Windows       []Window
+	BlackoutHours map[int]bool
+	Weekdays      map[time.Weekday]bool
+	Location      *time.Location
+	PoolMaxAge    time.Duration
+}
+
+var TheCalendar = &Calendar{
+	Windows:  []Window{{From: 19, To: 59}},
+	Location: time.Local,
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func Init() {
+	c, err := Parse(config.TheConfig)
+	if err != nil {
+		log.Fatalf("error parsing trading calendar: %v", err)
+	}
+	TheCalendar = c
+}
+
+func Parse(cfg *config.Config) (*Calendar, error) {
+	c := &Calendar{
+		BlackoutHours: make(map[int]bool),
+		Location:      time.Local,
+	}
+	for _, w := range cfg.TradingMinuteWindows {
+		from, to, found := strings.Cut(strings.TrimSpace(w), "-")
+		if !found {
+			to = from
+		}
+		f, err := strconv.Atoi(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %s: %w", w, err)
+		}
+		t, err := strconv.Atoi(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %s: %w", w, err)
+		}
+		if f < 0 || t > 59 || f > t {
+			return nil, fmt.Errorf("invalid window %s", w)
+		}
+		c.Windows = append(c.Windows, Window{From: f, To: t})
+	}
+	for _, h := range cfg.TradingBlackoutHours {
+		if h < 0 || h > 23 {
+			return nil, fmt.Errorf("invalid blackout hour %d", h)
+		}
+		c.BlackoutHours[h] = true
+	}
+	if len(cfg.TradingWeekdays) > 0 {
+		c.Weekdays = make(map[time.Weekday]bool)
+		for _, d := range cfg.TradingWeekdays {
+			day := strings.ToLower(strings.TrimSpace(d))
+			if len(day) > 3 {
+				day = day[:3]
+			}
+			wd, ok := weekdays[day]
+			if !ok {
+				return nil, fmt.Errorf("invalid weekday %s", d)
+			}
+			c.Weekdays[wd] = true
+		}
+	}
+	if cfg.TradingTimezone != "" {
+		loc, err := time.LoadLocation(cfg.TradingTimezone)
+		if err != nil {
+			return nil, err
+		}
+		c.Location = loc
+	}
+	if cfg.TradingPoolMaxAgeMinutes > 0 {
+		c.PoolMaxAge = time.Duration(cfg.TradingPoolMaxAgeMinutes) * time.Minute
+	}
+	return c, nil
+}
+
+// IsOpen tells if t falls into an allowed window, the reason is set when it does not
+func (c *Calendar) IsOpen(t time.Time) (bool, string) {
+	t = t.In(c.Location)
+	if c.Weekdays != nil && !c.Weekdays[t.Weekday()] {
+		return false, fmt.Sprintf("No trading on %s", t.Weekday())
+	}
+	if c.BlackoutHours[t.Hour()] {
+		return false, fmt.Sprintf("Blackout hour %d", t.Hour())
+	}
+	for _, w := range c.Windows {
+		if t.Minute() >= w.From && t.Minute() <= w.To {
+			return true, ""
+		}
+	}
+	return false, fmt.Sprintf("Minute %d outside trading windows %s", t.Minute(), c.windowsString())
+}
+
+// NextOpen is the first open minute starting from the next hour, which is the first window
+// that trades on a freshly refreshed pool. It steps over days and hours rather than minutes,
+// within an allowed hour the earliest window opens first.
+func (c *Calendar) NextOpen(t time.Time) time.Time {
+	t = t.In(c.Location)
+	start := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, c.Location)
+	first := -1
+	for _, w := range c.Windows {
+		if first < 0 || w.From < first {
+			first = w.From
+		}
+	}
+	if first < 0 {
+		return start
+	}
+	for h := start; h.Before(start.Add(8 * 24 * time.Hour)); {
+		if c.Weekdays != nil && !c.Weekdays[h.Weekday()] {
+			h = time.Date(h.Year(), h.Month(), h.Day()+1, 0, 0, 0, 0, c.Location)
+			continue
+		}
+		if !c.BlackoutHours[h.Hour()] {
+			return h.Add(time.Duration(first) * time.Minute)
+		}
+		h = time.Date(h.Year(), h.Month(), h.Day(), h.Hour()+1, 0, 0, 0, c.Location)
+	}
+	return start
+}
+
+// CanTrade checks the calendar at the current time and, when PoolMaxAge is set, that the pool
+// was refreshed recently enough. poolRefreshedAt is only called for that check, an error leaves
+// the pool age unknown and trading is skipped.
+func (c *Calendar) CanTrade(poolRefreshedAt func() (time.Time, error)) (bool, string) {
+	now := clock.Now()
+	if open, reason := c.IsOpen(now); !open {
+		return false, reason
+	}
+	if c.PoolMaxAge <= 0 {
+		return true, ""
+	}
+	refreshedAt, err := poolRefreshedAt()
+	if err != nil {
+		return false, fmt.Sprintf("Pool refresh time unknown (%v)", err)
+	}
+	if now.Sub(refreshedAt) > c.PoolMaxAge {
+		return false, fmt.Sprintf("Pool refreshed %s ago (max %s)",
+			now.Sub(refreshedAt).Round(time.Second), c.PoolMaxAge)
+	}
+	return true, ""
+}
+
+func (c *Calendar) TillNextOpen() time.Duration {
+	now := clock.Now()
+	return c.NextOpen(now).Sub(now)
+}
+
+func (c *Calendar) windowsString() string {
+	var ss []string
+	for _, w := range c.Windows {
+		ss = append(ss, fmt.Sprintf("%d-%d", w.From, w.To))
+	}
+	return strings.Join(ss, ",")
+}
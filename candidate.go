@@ -0,0 +1,206 @@
+package main
+
+import (
+	"BinanceTopStrategies/blacklist"
+	"BinanceTopStrategies/config"
+	"BinanceTopStrategies/discord"
+	"BinanceTopStrategies/funding"
+	"BinanceTopStrategies/gsp"
+	"BinanceTopStrategies/request"
+	"BinanceTopStrategies/utils"
+	"errors"
+	"fmt"
+	mapset "github.com/deckarep/golang-set/v2"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// candidate is a pool strategy checked against everything that doesn't depend on what is placed before it
+type candidate struct {
+	Pool          *gsp.Strategy
+	Root          *gsp.Strategy // the running strategy behind Pool, nil when it is not running
+	Skip          string        // why the candidate can't be placed, empty when it passed
+	Blacklisted   bool
+	Quiet         bool // Skip is not worth reporting
+	WL            *gsp.WL
+	MinScore      float64
+	MinInput      float64
+	MaxRuntimeMin int
+	MarketPrice   float64
+}
+
+// evaluateCandidates checks sortedStrategies on a bounded worker pool, the result keeps the order of sortedStrategies.
+// A candidate whose data can't be fetched is skipped, only a failure that sinks every candidate (see isSystemic)
+// is returned and stops scheduling the rest.
+func evaluateCandidates(session *gsp.Session, sortedStrategies gsp.Strategies,
+	openSIDs mapset.Set[int], openSymbols mapset.Set[string]) ([]*candidate, error) {
+	candidates := make([]*candidate, len(sortedStrategies))
+	sem := make(chan struct{}, max(1, config.TheConfig.CandidateConcurrency))
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var systemicErr error
+	failing := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return systemicErr != nil
+	}
+	for i, s := range sortedStrategies {
+		if openSIDs.Contains(s.SID) || openSymbols.Contains(s.Symbol) ||
+			openSymbols.Contains(utils.OverwriteQuote(s.Symbol, "USDT", 4)) ||
+			openSymbols.Contains(utils.OverwriteQuote(s.Symbol, "USDC", 4)) {
+			candidates[i] = &candidate{Pool: s}
+			continue
+		}
+		sem <- struct{}{}
+		if failing() {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(i int, s *gsp.Strategy) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			c, err := evaluateCandidate(session, sortedStrategies, s)
+			if err == nil {
+				candidates[i] = c
+				return
+			}
+			if isSystemic(err) {
+				mu.Lock()
+				if systemicErr == nil {
+					systemicErr = err
+				}
+				mu.Unlock()
+				return
+			}
+			log.Errorf("Error evaluating candidate %d %s: %v", s.SID, s.Symbol, err)
+			candidates[i] = &candidate{Pool: s, Skip: fmt.Sprintf("Error evaluating candidate: %v, Skip", err)}
+		}(i, s)
+	}
+	wg.Wait()
+	if systemicErr != nil {
+		return nil, systemicErr
+	}
+	return candidates, nil
+}
+
+// isSystemic tells whether err fails every request of the tick rather than the one candidate it came from
+func isSystemic(err error) bool {
+	return errors.Is(err, request.ErrLoginExpired)
+}
+
+func evaluateCandidate(session *gsp.Session, sortedStrategies gsp.Strategies, sInPool *gsp.Strategy) (*candidate, error) {
+	c := &candidate{Pool: sInPool}
+	if bl, till := blacklist.IsTradingBlocked(session.Account.BlacklistNamespace, sInPool.Symbol,
+		gsp.DirectionMap[sInPool.Direction]); bl {
+		c.Blacklisted = true
+		c.Skip = fmt.Sprintf("Symbol blacklisted till %s, Skip", till.Format("2006-01-02 15:04:05"))
+		return c, nil
+	}
+	userStrategies := 0
+	for _, ss := range sortedStrategies {
+		if sInPool.UserID == ss.UserID {
+			userStrategies++
+		}
+	}
+	if userStrategies > 4 {
+		c.Skip = fmt.Sprintf("User %d already has %d strategies in sorted, Skip", sInPool.UserID, userStrategies)
+		return c, nil
+	}
+	userWl, err := session.GetUserWL(sInPool.UserID)
+	if err != nil {
+		return nil, err
+	}
+	s, err := session.DiscoverRootStrategy(sInPool.SID, sInPool.Symbol, sInPool.Direction, time.Duration(sInPool.RunningTime)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		c.Skip = fmt.Sprintf("Strategy candidate %d %s not running", sInPool.SID, sInPool.Symbol)
+		return c, nil
+	}
+	c.Root = s
+	s.UserMetricsDB = sInPool.UserMetricsDB
+	err = session.PopulateRois(s)
+	if err != nil {
+		return nil, err
+	}
+	if s.Roi < 0 || (len(s.Rois) > 0 && s.Rois[0].Roi < 0) {
+		c.Skip = fmt.Sprintf("Strategy %d negative roi, Skip", s.SID)
+		c.Quiet = true
+		return c, nil
+	}
+
+	c.MarketPrice, _ = session.Prices.Get(s.Symbol)
+	gap := s.StrategyParams.UpperLimit - s.StrategyParams.LowerLimit
+	priceDiff := s.StrategyParams.UpperLimit/s.StrategyParams.LowerLimit - 1
+	minPriceDiff := 0.0
+	c.MinScore = config.TheConfig.WlMinScore
+	c.MinInput = 998.0
+	c.MaxRuntimeMin = 160
+	recenters := gsp.Recenters(s.Direction)
+	switch s.Direction {
+	case gsp.LONG:
+		if !recenters && c.MarketPrice > s.StrategyParams.UpperLimit-gap*config.TheConfig.LongRangeDiff {
+			c.Skip = "Market Price too high for long, Skip"
+			return c, nil
+		}
+		minPriceDiff = 0.02
+	case gsp.NEUTRAL:
+		minPriceDiff = 0.08
+		c.MinScore = config.TheConfig.WlMinScoreNeutral
+		c.MinInput = 2998.0
+		c.MaxRuntimeMin = 220
+	case gsp.SHORT:
+		if !recenters && c.MarketPrice < s.StrategyParams.LowerLimit+gap*config.TheConfig.ShortRangeDiff {
+			c.Skip = "Market Price too low for short, Skip"
+			return c, nil
+		}
+		minPriceDiff = 0.02
+	}
+	f, err := funding.Get(s.Symbol)
+	if err != nil {
+		discord.Errorf("Error getting funding for %s: %v", s.Symbol, err)
+	} else {
+		paid := math.Max(f.PaidRate(gsp.DirectionMap[s.Direction]), f.PaidAvgRate(gsp.DirectionMap[s.Direction]))
+		if paid > config.TheConfig.FundingMaxPaidRate {
+			c.Skip = fmt.Sprintf("Funding too expensive for %s %.4f%%, Skip", s.SD(), paid*100)
+			return c, nil
+		}
+		if paid > config.TheConfig.FundingPenaltyRate {
+			c.MinScore += config.TheConfig.FundingPenaltyScore
+		}
+	}
+	if priceDiff < minPriceDiff {
+		c.Skip = "Price difference too low, Skip"
+		return c, nil
+	}
+	c.WL = userWl.DirectionWL[s.Direction]
+	if c.WL.Score < c.MinScore {
+		c.Skip = fmt.Sprintf("WL score too low %.2f/%.2f (%.1f/%.1f), Skip", c.WL.Score, c.MinScore, c.WL.Win, c.WL.TotalWL)
+		return c, nil
+	}
+	if s.RunningTime > c.MaxRuntimeMin*60 {
+		c.Skip = fmt.Sprintf("Strategy %d running for more than %d minutes, Skip", s.SID, c.MaxRuntimeMin)
+		return c, nil
+	}
+
+	if s.StrategyParams.TriggerPrice != nil {
+		triggerPrice, _ := strconv.ParseFloat(*s.StrategyParams.TriggerPrice, 64)
+		diff := math.Abs((triggerPrice - c.MarketPrice) / c.MarketPrice)
+		if diff > config.TheConfig.TriggerRangeDiff {
+			c.Skip = fmt.Sprintf("Trigger Price difference too high, Skip, Trigger: %f, Market: %f, Diff: %f",
+				triggerPrice, c.MarketPrice, diff)
+			return c, nil
+		}
+	}
+
+	if !recenters && !s.MarketPriceWithinRange(session.Prices) {
+		c.Skip = "Market Price not within range, Skip"
+		return c, nil
+	}
+	return c, nil
+}
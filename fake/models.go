@@ -0,0 +1,126 @@
+package fake
+
+import "time"
+
+type StrategyParams struct {
+	Type           string  `json:"type"`
+	LowerLimit     string  `json:"lowerLimit"`
+	UpperLimit     string  `json:"upperLimit"`
+	GridCount      int     `json:"gridCount"`
+	TriggerPrice   *string `json:"triggerPrice"`
+	StopLowerLimit *string `json:"stopLowerLimit"`
+	StopUpperLimit *string `json:"stopUpperLimit"`
+	BaseAsset      any     `json:"baseAsset"`
+	QuoteAsset     any     `json:"quoteAsset"`
+	Leverage       int     `json:"leverage"`
+	TrailingUp     bool    `json:"trailingUp"`
+	TrailingDown   bool    `json:"trailingDown"`
+}
+
+// Strategy mirrors a single row of queryTopStrategy, it has to keep exactly 14 fields
+type Strategy struct {
+	Symbol             string         `json:"symbol"`
+	CopyCount          int            `json:"copyCount"`
+	Roi                string         `json:"roi"`
+	Pnl                string         `json:"pnl"`
+	RunningTime        int            `json:"runningTime"`
+	StrategyID         int            `json:"strategyId"`
+	StrategyType       int            `json:"strategyType"`
+	Direction          int            `json:"direction"`
+	UserID             int            `json:"userId"`
+	StrategyParams     StrategyParams `json:"strategyParams"`
+	TrailingType       string         `json:"trailingType"`
+	LatestMatchedCount int            `json:"latestMatchedCount"`
+	MatchedCount       int            `json:"matchedCount"`
+	MinInvestment      string         `json:"minInvestment"`
+}
+
+type Roi struct {
+	RootUserID int     `json:"rootUserId"`
+	StrategyID int     `json:"strategyId"`
+	Roi        float64 `json:"roi"`
+	Pnl        float64 `json:"pnl"`
+	Time       int64   `json:"time"` // millis
+}
+
+type Grid struct {
+	StrategyID             int    `json:"strategyId"`
+	ClientStrategyID       string `json:"clientStrategyId"`
+	RootUserID             int    `json:"rootUserId"`
+	StrategyUserID         int    `json:"strategyUserId"`
+	StrategyAccountID      int    `json:"strategyAccountId"`
+	Symbol                 string `json:"symbol"`
+	StrategyStatus         string `json:"strategyStatus"`
+	BookTime               int64  `json:"bookTime"`
+	TriggerTime            int64  `json:"triggerTime"`
+	UpdateTime             int64  `json:"updateTime"`
+	GridInitialValue       string `json:"gridInitialValue"`
+	InitialLeverage        int    `json:"initialLeverage"`
+	GridProfit             string `json:"gridProfit"`
+	Direction              string `json:"direction"`
+	MatchedPnl             string `json:"matchedPnl"`
+	UnmatchedAvgPrice      string `json:"unmatchedAvgPrice"`
+	UnmatchedQty           string `json:"unmatchedQty"`
+	UnmatchedFee           string `json:"unmatchedFee"`
+	GridEntryPrice         string `json:"gridEntryPrice"`
+	GridPosition           string `json:"gridPosition"`
+	Version                int    `json:"version"`
+	CopyCount              int    `json:"copyCount"`
+	CopiedStrategyID       int    `json:"copiedStrategyId"`
+	Sharing                bool   `json:"sharing"`
+	IsSubAccount           bool   `json:"isSubAccount"`
+	StrategyAmount         string `json:"strategyAmount"`
+	TrailingUp             bool   `json:"trailingUp"`
+	TrailingDown           bool   `json:"trailingDown"`
+	TrailingStopUpperLimit bool   `json:"trailingStopUpperLimit"`
+	TrailingStopLowerLimit bool   `json:"trailingStopLowerLimit"`
+	OrderCurrency          string `json:"orderCurrency"`
+	GridUpperLimit         string `json:"gridUpperLimit"`
+	GridLowerLimit         string `json:"gridLowerLimit"`
+	MatchedCount           int    `json:"matchedCount"`
+	GridCount              int    `json:"gridCount"`
+	PerGridQuoteQty        string `json:"perGridQuoteQty"`
+	PerGridQty             string `json:"perGridQty"`
+	FundingFee             string `json:"fundingFee"`
+	MarginType             string `json:"marginType"`
+}
+
+type Bracket struct {
+	BracketSeq                   int     `json:"bracketSeq"`
+	BracketNotionalFloor         int     `json:"bracketNotionalFloor"`
+	BracketNotionalCap           int     `json:"bracketNotionalCap"`
+	BracketMaintenanceMarginRate float64 `json:"bracketMaintenanceMarginRate"`
+	CumFastMaintenanceAmount     float64 `json:"cumFastMaintenanceAmount"`
+	MinOpenPosLeverage           int     `json:"minOpenPosLeverage"`
+	MaxOpenPosLeverage           int     `json:"maxOpenPosLeverage"`
+}
+
+type SymbolBrackets struct {
+	Symbol        string     `json:"symbol"`
+	UpdateTime    int64      `json:"updateTime"`
+	NotionalLimit int        `json:"notionalLimit"`
+	RiskBrackets  []*Bracket `json:"riskBrackets"`
+}
+
+type Kline struct {
+	OpenTime  int64
+	Open      string
+	High      string
+	Low       string
+	Close     string
+	Volume    string
+	CloseTime int64
+}
+
+type Balance struct {
+	Asset            string `json:"asset"`
+	Balance          string `json:"balance"`
+	CrossUnPnl       string `json:"crossUnPnl"`
+	AvailableBalance string `json:"availableBalance"`
+}
+
+type Funding struct {
+	Rate            float64
+	NextFundingTime time.Time
+	History         []float64
+}
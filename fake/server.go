@@ -0,0 +1,531 @@
+package fake
+
+import (
+	"BinanceTopStrategies/clock"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	TopStrategyPath = "/bapi/futures/v1/public/future/common/strategy/landing-page/queryTopStrategy"
+	RoiChartPath    = "/bapi/futures/v1/public/future/common/strategy/landing-page/queryRoiChart"
+	OpenGridsPath   = "/bapi/futures/v2/private/future/grid/query-open-grids"
+	PlaceGridPath   = "/bapi/futures/v2/private/future/grid/place-grid"
+	CloseGridPath   = "/bapi/futures/v1/private/future/grid/close-grid"
+	GridHistoryPath = "/bapi/futures/v2/private/future/grid/query-history-grids"
+	BracketsPath    = "/bapi/futures/v1/friendly/future/common/brackets"
+	KlinesPath      = "/fapi/v1/klines"
+	TickerPricePath = "/fapi/v2/ticker/price"
+	BalancePath     = "/fapi/v2/balance"
+	PremiumPath     = "/fapi/v1/premiumIndex"
+	FundingRatePath = "/fapi/v1/fundingRate"
+)
+
+type Failure struct {
+	Code    string
+	Message string
+}
+
+var (
+	LoginExpired = Failure{Code: "100002001", Message: "Login expired"}
+	TooFrequent  = Failure{Code: "90805176", Message: "Create grid too frequently"}
+)
+
+// Scenario is the state the fake serves, every mutation is guarded so steps can be scripted
+// between ticks while the server is running
+type Scenario struct {
+	mutex      sync.Mutex
+	Strategies []*Strategy
+	Rois       map[int][]*Roi
+	OpenGrids  []*Grid
+	History    []*Grid
+	Brackets   []*SymbolBrackets
+	Klines     map[string][]*Kline
+	Prices     map[string]float64
+	Balances   map[string]*Balance
+	Fundings   map[string]*Funding
+	Placed     []map[string]interface{}
+	Closed     []int
+	failures   map[string][]Failure
+	steps      []func(s *Scenario)
+	nextGID    int
+}
+
+func NewScenario() *Scenario {
+	return &Scenario{
+		Rois:     make(map[int][]*Roi),
+		Klines:   make(map[string][]*Kline),
+		Prices:   make(map[string]float64),
+		Balances: make(map[string]*Balance),
+		Fundings: make(map[string]*Funding),
+		failures: make(map[string][]Failure),
+		nextGID:  1000,
+	}
+}
+
+// Do applies f right away, f changes the scenario through the helpers which take the lock themselves
+func (s *Scenario) Do(f func(s *Scenario)) {
+	f(s)
+}
+
+// Then queues a step that is applied by Next, useful to describe a run tick by tick
+func (s *Scenario) Then(f func(s *Scenario)) *Scenario {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.steps = append(s.steps, f)
+	return s
+}
+
+// Next applies the next queued step, false when there is none left
+func (s *Scenario) Next() bool {
+	s.mutex.Lock()
+	if len(s.steps) == 0 {
+		s.mutex.Unlock()
+		return false
+	}
+	step := s.steps[0]
+	s.steps = s.steps[1:]
+	s.mutex.Unlock()
+	step(s)
+	return true
+}
+
+// FailNext makes the next request on path fail with the given binance error
+func (s *Scenario) FailNext(path string, failure Failure) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failures[path] = append(s.failures[path], failure)
+}
+
+func (s *Scenario) AddStrategy(strategy *Strategy, rois ...*Roi) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Strategies = append(s.Strategies, strategy)
+	s.Rois[strategy.StrategyID] = append(s.Rois[strategy.StrategyID], rois...)
+}
+
+func (s *Scenario) RemoveStrategy(sid int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, strategy := range s.Strategies {
+		if strategy.StrategyID == sid {
+			s.Strategies = append(s.Strategies[:i], s.Strategies[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *Scenario) SetPrice(symbol string, price float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Prices[symbol] = price
+}
+
+func (s *Scenario) SetBalance(asset string, balance float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b := fmt.Sprintf("%f", balance)
+	s.Balances[asset] = &Balance{Asset: asset, Balance: b, CrossUnPnl: "0", AvailableBalance: b}
+}
+
+// SetGridPnl moves the realized profit of an open grid, which is what take profit and stop loss act on
+func (s *Scenario) SetGridPnl(gid int, profit float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, g := range s.OpenGrids {
+		if g.StrategyID == gid {
+			g.GridProfit = fmt.Sprintf("%f", profit)
+		}
+	}
+}
+
+// GoneGrid drops a grid as if it was closed outside the bot
+func (s *Scenario) GoneGrid(gid int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.removeGrid(gid)
+}
+
+func (s *Scenario) removeGrid(gid int) bool {
+	for i, g := range s.OpenGrids {
+		if g.StrategyID == gid {
+			s.OpenGrids = append(s.OpenGrids[:i], s.OpenGrids[i+1:]...)
+			g.StrategyStatus = "CANCELLED"
+			g.UpdateTime = clock.Now().UnixMilli()
+			s.History = append([]*Grid{g}, s.History...)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Scenario) popFailure(path string) *Failure {
+	failures := s.failures[path]
+	if len(failures) == 0 {
+		return nil
+	}
+	s.failures[path] = failures[1:]
+	return &failures[0]
+}
+
+type Server struct {
+	*httptest.Server
+	Scenario *Scenario
+}
+
+// NewServer starts a binance stand-in, point BINANCE_BASE_URL and FUTURES_BASE_URL at Server.URL
+func NewServer(scenario *Scenario) *Server {
+	server := &Server{Scenario: scenario}
+	mux := http.NewServeMux()
+	mux.HandleFunc(TopStrategyPath, server.bapi(TopStrategyPath, server.topStrategy))
+	mux.HandleFunc(RoiChartPath, server.bapi(RoiChartPath, server.roiChart))
+	mux.HandleFunc(OpenGridsPath, server.bapi(OpenGridsPath, server.openGrids))
+	mux.HandleFunc(PlaceGridPath, server.bapi(PlaceGridPath, server.placeGrid))
+	mux.HandleFunc(CloseGridPath, server.bapi(CloseGridPath, server.closeGrid))
+	mux.HandleFunc(GridHistoryPath, server.bapi(GridHistoryPath, server.gridHistory))
+	mux.HandleFunc(BracketsPath, server.bapi(BracketsPath, server.brackets))
+	mux.HandleFunc(KlinesPath, server.fapi(server.klines))
+	mux.HandleFunc(TickerPricePath, server.fapi(server.tickerPrice))
+	mux.HandleFunc(BalancePath, server.fapi(server.balance))
+	mux.HandleFunc(PremiumPath, server.fapi(server.premiumIndex))
+	mux.HandleFunc(FundingRatePath, server.fapi(server.fundingRate))
+	server.Server = httptest.NewServer(mux)
+	return server
+}
+
+type bapiHandler func(body map[string]interface{}) (interface{}, error)
+
+func (server *Server) bapi(path string, handler bapiHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := make(map[string]interface{})
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		s := server.Scenario
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		response := map[string]interface{}{
+			"code":          "000000",
+			"message":       nil,
+			"messageDetail": nil,
+			"success":       true,
+		}
+		if failure := s.popFailure(path); failure != nil {
+			response["code"] = failure.Code
+			response["message"] = failure.Message
+			response["success"] = false
+			writeJson(w, response)
+			return
+		}
+		data, err := handler(body)
+		if err != nil {
+			response["code"] = "000002"
+			response["message"] = err.Error()
+			response["success"] = false
+			writeJson(w, response)
+			return
+		}
+		if top, ok := data.(topStrategyData); ok {
+			response["data"] = top.Data
+			response["total"] = top.Total
+		} else {
+			response["data"] = data
+		}
+		writeJson(w, response)
+	}
+}
+
+func (server *Server) fapi(handler func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := server.Scenario
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		data, err := handler(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJson(w, map[string]interface{}{"code": -1121, "msg": err.Error()})
+			return
+		}
+		writeJson(w, data)
+	}
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func number(body map[string]interface{}, key string) int {
+	v, _ := body[key].(float64)
+	return int(v)
+}
+
+type topStrategyData struct {
+	Data  []*Strategy
+	Total int
+}
+
+func (server *Server) topStrategy(body map[string]interface{}) (interface{}, error) {
+	s := server.Scenario
+	symbol, _ := body["symbol"].(string)
+	direction, hasDirection := body["direction"].(float64)
+	strategyType := number(body, "strategyType")
+	runtimeMin := number(body, "runningTimeMin")
+	runtimeMax := number(body, "runningTimeMax")
+	rows := number(body, "rows")
+	sortBy, _ := body["sort"].(string)
+	filtered := make([]*Strategy, 0)
+	for _, strategy := range s.Strategies {
+		if symbol != "" && strategy.Symbol != symbol {
+			continue
+		}
+		if hasDirection && strategy.Direction != int(direction) {
+			continue
+		}
+		if strategyType != 0 && strategy.StrategyType != strategyType {
+			continue
+		}
+		if strategy.RunningTime < runtimeMin || (runtimeMax != 0 && strategy.RunningTime > runtimeMax) {
+			continue
+		}
+		filtered = append(filtered, strategy)
+	}
+	sort.SliceStable(filtered, func(i, j int) bool {
+		switch sortBy {
+		case "roi":
+			a, _ := strconv.ParseFloat(filtered[i].Roi, 64)
+			b, _ := strconv.ParseFloat(filtered[j].Roi, 64)
+			return a > b
+		case "copyCount":
+			return filtered[i].CopyCount > filtered[j].CopyCount
+		case "latestMatchedCount":
+			return filtered[i].LatestMatchedCount > filtered[j].LatestMatchedCount
+		default:
+			a, _ := strconv.ParseFloat(filtered[i].Pnl, 64)
+			b, _ := strconv.ParseFloat(filtered[j].Pnl, 64)
+			return a > b
+		}
+	})
+	total := len(filtered)
+	if rows > 0 && len(filtered) > rows {
+		filtered = filtered[:rows]
+	}
+	return topStrategyData{Data: filtered, Total: total}, nil
+}
+
+func (server *Server) roiChart(body map[string]interface{}) (interface{}, error) {
+	rois, ok := server.Scenario.Rois[number(body, "strategyId")]
+	if !ok {
+		return []*Roi{}, nil
+	}
+	return rois, nil
+}
+
+func (server *Server) openGrids(_ map[string]interface{}) (interface{}, error) {
+	grids := server.Scenario.OpenGrids
+	if grids == nil {
+		grids = make([]*Grid, 0)
+	}
+	return grids, nil
+}
+
+func (server *Server) placeGrid(body map[string]interface{}) (interface{}, error) {
+	s := server.Scenario
+	symbol, _ := body["symbol"].(string)
+	if _, ok := s.Prices[symbol]; !ok {
+		return nil, fmt.Errorf("symbol %s not found", symbol)
+	}
+	s.nextGID++
+	now := clock.Now().UnixMilli()
+	str := func(key string) string {
+		v, _ := body[key].(string)
+		return v
+	}
+	b := func(key string) bool {
+		v, _ := body[key].(bool)
+		return v
+	}
+	price := fmt.Sprintf("%f", s.Prices[symbol])
+	grid := &Grid{
+		StrategyID:             s.nextGID,
+		ClientStrategyID:       str("clientStrategyId"),
+		Symbol:                 symbol,
+		StrategyStatus:         "WORKING",
+		BookTime:               now,
+		TriggerTime:            now,
+		UpdateTime:             now,
+		GridInitialValue:       str("gridInitialValue"),
+		InitialLeverage:        number(body, "leverage"),
+		GridProfit:             "0",
+		Direction:              str("direction"),
+		MatchedPnl:             "0",
+		UnmatchedAvgPrice:      "0",
+		UnmatchedQty:           "0",
+		UnmatchedFee:           "0",
+		GridEntryPrice:         price,
+		GridPosition:           "0",
+		CopiedStrategyID:       number(body, "copiedStrategyId"),
+		StrategyAmount:         str("gridInitialValue"),
+		TrailingUp:             b("trailingUp"),
+		TrailingDown:           b("trailingDown"),
+		TrailingStopUpperLimit: b("trailingStopUpperLimit"),
+		TrailingStopLowerLimit: b("trailingStopLowerLimit"),
+		OrderCurrency:          str("orderCurrency"),
+		GridUpperLimit:         str("gridUpperLimit"),
+		GridLowerLimit:         str("gridLowerLimit"),
+		GridCount:              number(body, "gridCount"),
+		PerGridQuoteQty:        "0",
+		PerGridQty:             "0",
+		FundingFee:             "0",
+		MarginType:             str("marginType"),
+	}
+	s.OpenGrids = append(s.OpenGrids, grid)
+	s.Placed = append(s.Placed, body)
+	return map[string]interface{}{
+		"strategyId":       grid.StrategyID,
+		"clientStrategyId": grid.ClientStrategyID,
+		"strategyType":     "UM_GRID",
+		"strategyStatus":   grid.StrategyStatus,
+		"updateTime":       now,
+	}, nil
+}
+
+func (server *Server) closeGrid(body map[string]interface{}) (interface{}, error) {
+	s := server.Scenario
+	gid := number(body, "strategyId")
+	if !s.removeGrid(gid) {
+		return nil, fmt.Errorf("grid %d not found", gid)
+	}
+	s.Closed = append(s.Closed, gid)
+	return nil, nil
+}
+
+func (server *Server) gridHistory(body map[string]interface{}) (interface{}, error) {
+	grids := server.Scenario.History
+	page, rows := number(body, "page"), number(body, "rows")
+	if page > 0 && rows > 0 {
+		from := min((page-1)*rows, len(grids))
+		grids = grids[from:min(from+rows, len(grids))]
+	}
+	if grids == nil {
+		grids = make([]*Grid, 0)
+	}
+	return grids, nil
+}
+
+func (server *Server) brackets(_ map[string]interface{}) (interface{}, error) {
+	brackets := server.Scenario.Brackets
+	if brackets == nil {
+		brackets = make([]*SymbolBrackets, 0)
+	}
+	return map[string]interface{}{"brackets": brackets}, nil
+}
+
+func query(r *http.Request, key string) int64 {
+	v, _ := strconv.ParseInt(r.URL.Query().Get(key), 10, 64)
+	return v
+}
+
+func (server *Server) klines(r *http.Request) (interface{}, error) {
+	symbol := r.URL.Query().Get("symbol")
+	startTime := query(r, "startTime")
+	endTime := query(r, "endTime")
+	limit := query(r, "limit")
+	res := make([][]interface{}, 0)
+	for _, k := range server.Scenario.Klines[symbol+"-"+r.URL.Query().Get("interval")] {
+		if (startTime != 0 && k.OpenTime < startTime) || (endTime != 0 && k.OpenTime > endTime) {
+			continue
+		}
+		res = append(res, []interface{}{k.OpenTime, k.Open, k.High, k.Low, k.Close, k.Volume,
+			k.CloseTime, "0", 0, "0", "0", "0"})
+		if limit != 0 && int64(len(res)) >= limit {
+			break
+		}
+	}
+	return res, nil
+}
+
+// AddKlines registers klines under symbol and interval, sorted by open time
+func (s *Scenario) AddKlines(symbol, interval string, klines ...*Kline) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := symbol + "-" + interval
+	s.Klines[key] = append(s.Klines[key], klines...)
+	sort.Slice(s.Klines[key], func(i, j int) bool {
+		return s.Klines[key][i].OpenTime < s.Klines[key][j].OpenTime
+	})
+}
+
+func (server *Server) tickerPrice(r *http.Request) (interface{}, error) {
+	symbol := r.URL.Query().Get("symbol")
+	if symbol != "" {
+		price, ok := server.Scenario.Prices[symbol]
+		if !ok {
+			return nil, fmt.Errorf("invalid symbol")
+		}
+		return map[string]interface{}{"symbol": symbol, "price": fmt.Sprintf("%f", price), "time": clock.Now().UnixMilli()}, nil
+	}
+	res := make([]map[string]interface{}, 0)
+	for sym, price := range server.Scenario.Prices {
+		res = append(res, map[string]interface{}{"symbol": sym, "price": fmt.Sprintf("%f", price), "time": clock.Now().UnixMilli()})
+	}
+	return res, nil
+}
+
+func (server *Server) balance(_ *http.Request) (interface{}, error) {
+	res := make([]*Balance, 0)
+	for _, b := range server.Scenario.Balances {
+		res = append(res, b)
+	}
+	return res, nil
+}
+
+// SetFunding sets the next funding rate of symbol together with its settled history
+func (s *Scenario) SetFunding(symbol string, rate float64, next time.Time, history ...float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Fundings[symbol] = &Funding{Rate: rate, NextFundingTime: next, History: history}
+}
+
+func (server *Server) funding(symbol string) *Funding {
+	f, ok := server.Scenario.Fundings[symbol]
+	if !ok {
+		return &Funding{NextFundingTime: clock.Now().Truncate(8 * time.Hour).Add(8 * time.Hour)}
+	}
+	return f
+}
+
+func (server *Server) premiumIndex(r *http.Request) (interface{}, error) {
+	symbol := r.URL.Query().Get("symbol")
+	f := server.funding(symbol)
+	price := fmt.Sprintf("%f", server.Scenario.Prices[symbol])
+	return map[string]interface{}{
+		"symbol":               symbol,
+		"markPrice":            price,
+		"indexPrice":           price,
+		"estimatedSettlePrice": price,
+		"lastFundingRate":      fmt.Sprintf("%f", f.Rate),
+		"nextFundingTime":      f.NextFundingTime.UnixMilli(),
+		"interestRate":         "0.0001",
+		"time":                 clock.Now().UnixMilli(),
+	}, nil
+}
+
+func (server *Server) fundingRate(r *http.Request) (interface{}, error) {
+	symbol := r.URL.Query().Get("symbol")
+	f := server.funding(symbol)
+	res := make([]map[string]interface{}, 0)
+	for i, rate := range f.History {
+		res = append(res, map[string]interface{}{
+			"symbol":      symbol,
+			"fundingRate": fmt.Sprintf("%f", rate),
+			"fundingTime": f.NextFundingTime.Add(-time.Duration(len(f.History)-i) * 8 * time.Hour).UnixMilli(),
+			"markPrice":   fmt.Sprintf("%f", server.Scenario.Prices[symbol]),
+		})
+	}
+	return res, nil
+}
@@ -0,0 +1,40 @@
+package fake
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestScenarioStepsUseHelpers(t *testing.T) {
+	s := NewScenario()
+	s.Do(func(s *Scenario) { s.SetBalance("USDT", 100) })
+	s.Then(func(s *Scenario) { s.SetPrice("BTCUSDT", 100) }).
+		Then(func(s *Scenario) { s.FailNext(OpenGridsPath, LoginExpired) })
+	steps := 0
+	for s.Next() {
+		steps++
+	}
+	if steps != 2 {
+		t.Fatalf("ran %d steps, want 2", steps)
+	}
+	if s.Prices["BTCUSDT"] != 100 || s.Balances["USDT"] == nil {
+		t.Fatal("steps not applied")
+	}
+
+	server := NewServer(s)
+	defer server.Close()
+	resp, err := http.Post(server.URL+OpenGridsPath, "application/json", bytes.NewBufferString("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body := make(map[string]interface{})
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["code"] != LoginExpired.Code {
+		t.Fatalf("got code %v, want %s", body["code"], LoginExpired.Code)
+	}
+}
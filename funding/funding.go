@@ -0,0 +1,106 @@
+package funding
+
+import (
+	"BinanceTopStrategies/cache"
+	"BinanceTopStrategies/clock"
+	"BinanceTopStrategies/config"
+	"BinanceTopStrategies/sdk"
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+type Funding struct {
+	Symbol          string
+	Rate            float64 // predicted rate of the next funding
+	AvgRate         float64 // average of the recent settled rates
+	NextFundingTime time.Time
+	FetchedAt       time.Time
+}
+
+var fundingCache = &cache.MapCache[*Funding]{
+	Name:      "funding",
+	Retention: 10 * time.Minute,
+	FetchMethod: func(symbol string) (*Funding, error) {
+		return fetch(symbol)
+	},
+	HasExpired: func(f *Funding) bool {
+		return clock.Since(f.FetchedAt) > 5*time.Minute || clock.Now().After(f.NextFundingTime)
+	},
+}
+
+func Get(symbol string) (*Funding, error) {
+	return fundingCache.Get(symbol)
+}
+
+func fetch(symbol string) (*Funding, error) {
+	premium, err := sdk.FuturesClient.NewPremiumIndexService().Symbol(symbol).Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	f := &Funding{Symbol: symbol, FetchedAt: clock.Now()}
+	found := false
+	for _, p := range premium {
+		if p.Symbol == symbol {
+			f.Rate, err = strconv.ParseFloat(p.LastFundingRate, 64)
+			if err != nil {
+				return nil, err
+			}
+			f.NextFundingTime = time.UnixMilli(p.NextFundingTime)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("premium index not found for %s", symbol)
+	}
+	history, err := sdk.FuturesClient.NewFundingRateService().Symbol(symbol).
+		Limit(config.TheConfig.FundingHistoryCount).Do(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	total := 0.0
+	for _, h := range history {
+		rate, err := strconv.ParseFloat(h.FundingRate, 64)
+		if err != nil {
+			return nil, err
+		}
+		total += rate
+	}
+	if len(history) > 0 {
+		f.AvgRate = total / float64(len(history))
+	} else {
+		f.AvgRate = f.Rate
+	}
+	return f, nil
+}
+
+func paid(rate float64, direction string) float64 {
+	switch direction {
+	case "LONG":
+		return rate
+	case "SHORT":
+		return -rate
+	}
+	return 0
+}
+
+// PaidRate is the next funding rate from the point of view of a position in direction, positive means we pay
+func (f *Funding) PaidRate(direction string) float64 {
+	return paid(f.Rate, direction)
+}
+
+// PaidAvgRate is the same as PaidRate but over the recent funding history
+func (f *Funding) PaidAvgRate(direction string) float64 {
+	return paid(f.AvgRate, direction)
+}
+
+func (f *Funding) TillNextFunding() time.Duration {
+	return f.NextFundingTime.Sub(clock.Now())
+}
+
+func (f *Funding) String() string {
+	return fmt.Sprintf("Funding: %.4f%% (avg %.4f%%) in %s", f.Rate*100, f.AvgRate*100,
+		f.TillNextFunding().Round(time.Minute))
+}
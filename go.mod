@@ -4,25 +4,29 @@ go 1.22
 
 require (
 	github.com/adshao/go-binance/v2 v2.5.0
+	github.com/alicebob/miniredis/v2 v2.39.0
 	github.com/caarlos0/env v3.5.0+incompatible
 	github.com/deckarep/golang-set/v2 v2.6.0
+	github.com/fergusstrange/embedded-postgres v1.34.0
+	github.com/georgysavva/scany/v2 v2.1.3
 	github.com/go-co-op/gocron v1.37.0
 	github.com/gtuk/discordwebhook v1.2.0
+	github.com/jackc/pgx/v5 v5.5.5
 	github.com/redis/rueidis v1.0.34
 	github.com/sirupsen/logrus v1.9.3
 	github.com/syohex/go-texttable v0.0.0-20200919024338-eae5d131ba28
+	golang.org/x/sync v0.1.0
 )
 
 require (
 	github.com/bitly/go-simplejson v0.5.0 // indirect
-	github.com/georgysavva/scany/v2 v2.1.3 // indirect
 	github.com/google/uuid v1.4.0 // indirect
 	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
-	github.com/jackc/pgx/v5 v5.5.5 // indirect
 	github.com/jackc/puddle/v2 v2.2.1 // indirect
 	github.com/json-iterator/go v1.1.12 // indirect
+	github.com/lib/pq v1.10.9 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mattn/go-runewidth v0.0.15 // indirect
@@ -31,10 +35,11 @@ require (
 	github.com/rivo/uniseg v0.4.4 // indirect
 	github.com/robfig/cron/v3 v3.0.1 // indirect
 	github.com/rs/zerolog v1.33.0 // indirect
-	github.com/stretchr/testify v1.8.4 // indirect
+	github.com/stretchr/testify v1.10.0 // indirect
+	github.com/xi2/xz v0.0.0-20171230120015-48954b6210f8 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
 	go.uber.org/atomic v1.9.0 // indirect
 	golang.org/x/crypto v0.17.0 // indirect
-	golang.org/x/sync v0.1.0 // indirect
 	golang.org/x/sys v0.21.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 )
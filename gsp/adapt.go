@@ -0,0 +1,157 @@
+package gsp
+
+import (
+	"BinanceTopStrategies/config"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	PolicyCopy        = "copy"         // the leader's parameters verbatim
+	PolicyRecenter    = "recenter"     // the leader's width around the current price
+	PolicyShrinkCount = "shrink_count" // fewer grids so our chunk meets the min investment
+	PolicyKeepStops   = "keep_stops"   // the leader's stop limits even when the range moved
+
+	minGridCount = 2
+)
+
+// GridPolicies are the adaptation policies configured for a direction, applied in order
+func GridPolicies(direction int) []string {
+	var policies []string
+	switch direction {
+	case LONG:
+		policies = config.TheConfig.GridPolicyLong
+	case SHORT:
+		policies = config.TheConfig.GridPolicyShort
+	case NEUTRAL:
+		policies = config.TheConfig.GridPolicyNeutral
+	}
+	if len(policies) == 0 {
+		return []string{PolicyCopy}
+	}
+	return policies
+}
+
+// Recenters tells if the range of the direction follows the current price, the leader's range checks don't apply then
+func Recenters(direction int) bool {
+	return slices.Contains(GridPolicies(direction), PolicyRecenter)
+}
+
+// Adapt returns a copy of the strategy with the grid parameters to place at marketPrice with input at leverage,
+// the policies that changed the parameters are kept in GridPolicy, PolicyCopy when none did
+func Adapt(s *Strategy, marketPrice, input float64, leverage int) (*Strategy, error) {
+	adapted := *s
+	policies := GridPolicies(s.Direction)
+	applied := make([]string, 0, len(policies))
+	for _, policy := range policies {
+		switch policy {
+		case PolicyCopy, PolicyKeepStops:
+			// copy is what is left when nothing changed, keep_stops only acts through recenter
+		case PolicyRecenter:
+			changed, keptStops, err := recenter(&adapted, marketPrice, slices.Contains(policies, PolicyKeepStops))
+			if err != nil {
+				return nil, err
+			}
+			if changed {
+				applied = append(applied, PolicyRecenter)
+			}
+			if keptStops {
+				applied = append(applied, PolicyKeepStops)
+			}
+		case PolicyShrinkCount:
+			if shrinkCount(&adapted, input, leverage) {
+				applied = append(applied, PolicyShrinkCount)
+			}
+		default:
+			return nil, fmt.Errorf("unknown grid policy: %s", policy)
+		}
+	}
+	if len(applied) == 0 {
+		applied = append(applied, PolicyCopy)
+	}
+	adapted.GridPolicy = strings.Join(applied, ",")
+	return &adapted, nil
+}
+
+// recenter tells if the range moved and if a stop was kept where the leader put it,
+// a kept stop inside the moved range would trigger right away so it fails instead
+func recenter(s *Strategy, marketPrice float64, keepStops bool) (bool, bool, error) {
+	p := &s.StrategyParams
+	width := p.UpperLimit - p.LowerLimit
+	shift := marketPrice - (p.LowerLimit+p.UpperLimit)/2
+	if marketPrice <= 0 || width <= 0 || shift == 0 {
+		return false, false, nil
+	}
+	if p.LowerLimit+shift <= 0 {
+		return false, false, fmt.Errorf("recentered range of %s below zero", s.Symbol)
+	}
+	decimals := priceDecimals(p.LowerLimitStr, p.UpperLimitStr)
+	p.LowerLimit += shift
+	p.UpperLimit += shift
+	p.LowerLimitStr = strconv.FormatFloat(p.LowerLimit, 'f', decimals, 64)
+	p.UpperLimitStr = strconv.FormatFloat(p.UpperLimit, 'f', decimals, 64)
+	keptStops := false
+	shiftStop := func(stop *string, inside func(float64) bool) (*string, error) {
+		if stop == nil {
+			return nil, nil
+		}
+		v, err := strconv.ParseFloat(*stop, 64)
+		if err != nil {
+			return stop, nil
+		}
+		if !keepStops {
+			str := strconv.FormatFloat(v+shift, 'f', decimals, 64)
+			return &str, nil
+		}
+		if inside(v) {
+			return nil, fmt.Errorf("stop %s of %s inside the recentered range %s-%s",
+				*stop, s.Symbol, p.LowerLimitStr, p.UpperLimitStr)
+		}
+		keptStops = true
+		return stop, nil
+	}
+	var err error
+	p.StopLowerLimit, err = shiftStop(p.StopLowerLimit, func(v float64) bool { return v >= p.LowerLimit })
+	if err != nil {
+		return false, false, err
+	}
+	p.StopUpperLimit, err = shiftStop(p.StopUpperLimit, func(v float64) bool { return v <= p.UpperLimit })
+	if err != nil {
+		return false, false, err
+	}
+	return true, keptStops, nil
+}
+
+// shrinkCount scales the grid count down to what input at leverage can fund, the leader's min investment is at its leverage
+func shrinkCount(s *Strategy, input float64, leverage int) bool {
+	minInvestment, err := strconv.ParseFloat(s.MinInvestment, 64)
+	if err != nil || minInvestment <= 0 || s.StrategyParams.Leverage <= 0 {
+		return false
+	}
+	required := minInvestment * float64(s.StrategyParams.Leverage)
+	available := input * float64(leverage)
+	if available >= required {
+		return false
+	}
+	count := int(math.Floor(float64(s.StrategyParams.GridCount) * available / required))
+	count = max(count, minGridCount)
+	if count >= s.StrategyParams.GridCount {
+		return false
+	}
+	s.MinInvestment = strconv.FormatFloat(minInvestment*float64(count)/float64(s.StrategyParams.GridCount), 'f', 2, 64)
+	s.StrategyParams.GridCount = count
+	return true
+}
+
+func priceDecimals(prices ...string) int {
+	decimals := 0
+	for _, p := range prices {
+		if i := strings.IndexByte(p, '.'); i >= 0 {
+			decimals = max(decimals, len(p)-i-1)
+		}
+	}
+	return decimals
+}
@@ -0,0 +1,76 @@
+package gsp
+
+import (
+	"BinanceTopStrategies/config"
+	"testing"
+)
+
+func adaptStrategy(stopLower, stopUpper *string) *Strategy {
+	return &Strategy{
+		Symbol:        "BTCUSDT",
+		Direction:     LONG,
+		MinInvestment: "10",
+		StrategyParams: StrategyParams{
+			LowerLimitStr: "90", UpperLimitStr: "110", LowerLimit: 90, UpperLimit: 110,
+			GridCount: 10, Leverage: 1, StopLowerLimit: stopLower, StopUpperLimit: stopUpper,
+		},
+	}
+}
+
+func TestAdaptRecordsChangingPolicies(t *testing.T) {
+	config.Init()
+	stop := "80"
+	tests := []struct {
+		name     string
+		policies []string
+		s        *Strategy
+		price    float64
+		input    float64
+		want     string
+	}{
+		{"nothing changed", []string{PolicyCopy, PolicyShrinkCount}, adaptStrategy(nil, nil), 100, 100, PolicyCopy},
+		{"recenter without stops", []string{PolicyRecenter, PolicyKeepStops}, adaptStrategy(nil, nil), 105, 100, PolicyRecenter},
+		{"recenter at the price", []string{PolicyRecenter, PolicyKeepStops}, adaptStrategy(&stop, nil), 100, 100, PolicyCopy},
+		{"kept stop", []string{PolicyKeepStops, PolicyRecenter}, adaptStrategy(&stop, nil), 105, 100, "recenter,keep_stops"},
+		{"shrunk", []string{PolicyCopy, PolicyShrinkCount}, adaptStrategy(nil, nil), 100, 5, PolicyShrinkCount},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.TheConfig.GridPolicyLong = tt.policies
+			adapted, err := Adapt(tt.s, tt.price, tt.input, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if adapted.GridPolicy != tt.want {
+				t.Fatalf("got %s, want %s", adapted.GridPolicy, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdaptKeptStopInsideRange(t *testing.T) {
+	config.Init()
+	config.TheConfig.GridPolicyLong = []string{PolicyRecenter, PolicyKeepStops}
+	stop := "96"
+	s := adaptStrategy(&stop, nil)
+	_, err := Adapt(s, 105, 100, 1)
+	if err == nil {
+		t.Fatal("stop inside the recentered range not rejected")
+	}
+	if s.StrategyParams.LowerLimit != 90 || *s.StrategyParams.StopLowerLimit != "96" {
+		t.Fatal("leader's strategy modified")
+	}
+}
+
+func TestAdaptShiftsStops(t *testing.T) {
+	config.Init()
+	config.TheConfig.GridPolicyLong = []string{PolicyRecenter}
+	stop := "92"
+	adapted, err := Adapt(adaptStrategy(&stop, nil), 105, 100, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *adapted.StrategyParams.StopLowerLimit != "97" {
+		t.Fatalf("got stop %s, want 97", *adapted.StrategyParams.StopLowerLimit)
+	}
+}
@@ -1,7 +1,7 @@
 package gsp
 
 import (
-	"BinanceTopStrategies/config"
+	"BinanceTopStrategies/account"
 	"BinanceTopStrategies/discord"
 	"BinanceTopStrategies/request"
 	"BinanceTopStrategies/utils"
@@ -13,6 +13,7 @@ import (
 
 type gridToCancel struct {
 	MaxLoss   float64
+	Rules     []string
 	Reasons   []string
 	Grid      *Grid
 	Cancelled bool
@@ -24,32 +25,37 @@ func (tc *gridToCancel) canCancel() bool {
 	return tc.Grid.LastRoi >= tc.MaxLoss
 }
 
-func closeGrid(strategyId int) error {
-	if config.TheConfig.Paper {
+func closeGrid(acc *account.Account, strategyId int) error {
+	if acc.Paper {
 		log.Infof("Paper mode, not closing grid")
 		return nil
 	}
-	url := "https://www.binance.com/bapi/futures/v1/private/future/grid/close-grid"
+	url := request.Url("/bapi/futures/v1/private/future/grid/close-grid")
 	payload := map[string]interface{}{
 		"strategyId": strategyId,
 	}
-	_, _, err := request.PrivateRequest(url, "POST", payload, &request.BinanceBaseResponse{})
+	_, _, err := request.PrivateRequest(acc, url, "POST", payload, &request.BinanceBaseResponse{})
 	return err
 }
 
-func (tc *gridToCancel) Cancel() error {
+func (tc *gridToCancel) Cancel(session *Session) error {
+	acc := session.Account
 	grid := tc.Grid
 	webhooks := []int{discord.DefaultWebhook}
 	if tc.canCancel() {
-		err := closeGrid(grid.GID)
+		err := closeGrid(acc, grid.GID)
 		if err != nil {
 			return err
 		}
 		tc.Cancelled = true
-		discord.Actionf(Display(nil, grid, "**Cancelled**", 0, 0))
+		if !acc.Paper {
+			// our last snapshot for now, UpdateOpenGrids settles it from the history once the grid is gone
+			newClosedGrid(grid, tc.Rules[0], tc.Reasons).insert()
+		}
+		discord.Actionf(session.Display(nil, grid, "**Cancelled**", 0, 0))
 		webhooks = append(webhooks, discord.ActionWebhook)
 	} else {
-		discord.Infof(Display(nil, grid, "**Skip Cancel**", 0, 0))
+		discord.Infof(session.Display(nil, grid, "**Skip Cancel**", 0, 0))
 	}
 	for _, reason := range tc.Reasons {
 		discord.Webhooks(" * "+reason, webhooks...)
@@ -57,16 +63,16 @@ func (tc *gridToCancel) Cancel() error {
 	return nil
 }
 
-func (g GridsToCancel) CancelAll() {
+func (g GridsToCancel) CancelAll(session *Session) {
 	for _, tc := range g {
-		err := tc.Cancel()
+		err := tc.Cancel(session)
 		if err != nil {
 			discord.Infof("Error cancelling grid: %v", err)
 		}
 	}
 }
 
-func (g GridsToCancel) AddGridToCancel(grid *Grid, maxLoss float64, reason string) {
+func (g GridsToCancel) AddGridToCancel(grid *Grid, maxLoss float64, rule, reason string) {
 	tc, ok := g[grid.GID]
 	if !ok {
 		tc = &gridToCancel{
@@ -77,6 +83,7 @@ func (g GridsToCancel) AddGridToCancel(grid *Grid, maxLoss float64, reason strin
 	} else if maxLoss < tc.MaxLoss {
 		tc.MaxLoss = maxLoss
 	}
+	tc.Rules = append(tc.Rules, rule)
 	tc.Reasons = append(tc.Reasons, reason)
 }
 
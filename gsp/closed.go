@@ -0,0 +1,174 @@
+package gsp
+
+import (
+	"BinanceTopStrategies/account"
+	"BinanceTopStrategies/clock"
+	"BinanceTopStrategies/discord"
+	"BinanceTopStrategies/request"
+	"BinanceTopStrategies/sql"
+	"context"
+	"github.com/jackc/pgx/v5"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ExitTakeProfit = "take_profit"
+	ExitStopLoss   = "stop_loss"
+	ExitNotRunning = "not_running"
+	ExitFunding    = "funding"
+	ExitGone       = "gone"
+)
+
+type ClosedGrid struct {
+	GID          int       `db:"gid"`
+	SID          int       `db:"strategy_id"`
+	Symbol       string    `db:"symbol"`
+	Direction    string    `db:"direction"`
+	InitialValue float64   `db:"initial_value"`
+	Leverage     int       `db:"leverage"`
+	RealizedPnl  float64   `db:"realized_pnl"`
+	Pnl          float64   `db:"pnl"`
+	Roi          float64   `db:"roi"`
+	FundingFee   float64   `db:"funding_fee"`
+	Fee          float64   `db:"fee"`
+	ExitRule     string    `db:"exit_rule"`
+	ExitReasons  string    `db:"exit_reasons"`
+	OpenedAt     time.Time `db:"opened_at"`
+	ClosedAt     time.Time `db:"closed_at"`
+	HoldTime     int64     `db:"hold_time"`
+}
+
+type gridHistoryResponse struct {
+	Grids Grids `json:"data"`
+	request.BinanceBaseResponse
+}
+
+const (
+	historyRows     = 50
+	historyMaxPages = 20
+)
+
+func getGridHistory(acc *account.Account, page int) (Grids, error) {
+	url := request.Url("/bapi/futures/v2/private/future/grid/query-history-grids")
+	payload := map[string]interface{}{
+		"page": page,
+		"rows": historyRows,
+	}
+	res, _, err := request.PrivateRequest(acc, url, "POST", payload, &gridHistoryResponse{})
+	if err != nil {
+		return nil, err
+	}
+	return res.Grids, nil
+}
+
+// gridHistory pages through the closed grids of an account as they are looked up, newest first
+type gridHistory struct {
+	acc   *account.Account
+	grids Grids
+	page  int
+	done  bool
+	err   error
+}
+
+func newGridHistory(acc *account.Account) *gridHistory {
+	return &gridHistory{acc: acc}
+}
+
+// find fetches pages until gid shows up, or until a page reaches grids updated before the grid was booked
+func (h *gridHistory) find(gid int, bookTime int64) (*Grid, error) {
+	for {
+		if g := h.grids.FindGID(gid); g != nil {
+			return g, nil
+		}
+		if h.err != nil {
+			return nil, h.err
+		}
+		if h.done || (len(h.grids) > 0 && h.grids[len(h.grids)-1].UpdateTime < bookTime) {
+			return nil, nil
+		}
+		h.page++
+		grids, err := getGridHistory(h.acc, h.page)
+		if err != nil {
+			h.err = err
+			return nil, err
+		}
+		h.grids = append(h.grids, grids...)
+		h.done = len(grids) < historyRows || h.page >= historyMaxPages
+	}
+}
+
+func newClosedGrid(grid *Grid, rule string, reasons []string) *ClosedGrid {
+	fundingFee, _ := strconv.ParseFloat(grid.FundingFee, 64)
+	fee, _ := strconv.ParseFloat(grid.UnmatchedFee, 64)
+	openedAt := time.UnixMilli(grid.BookTime)
+	closedAt := clock.Now()
+	return &ClosedGrid{
+		GID:          grid.GID,
+		SID:          grid.SID,
+		Symbol:       grid.Symbol,
+		Direction:    grid.Direction,
+		InitialValue: grid.InitialValue,
+		Leverage:     grid.InitialLeverage,
+		RealizedPnl:  grid.LastRealizedPnl,
+		Pnl:          grid.LastPnl,
+		Roi:          grid.LastRoi,
+		FundingFee:   fundingFee,
+		Fee:          fee,
+		ExitRule:     rule,
+		ExitReasons:  strings.Join(reasons, "; "),
+		OpenedAt:     openedAt,
+		ClosedAt:     closedAt,
+		HoldTime:     int64(closedAt.Sub(openedAt).Seconds()),
+	}
+}
+
+// settle replaces our last snapshot with the final state binance reports for the grid, final may be nil
+func (c *ClosedGrid) settle(final *Grid) {
+	if final == nil {
+		return
+	}
+	realized, err := strconv.ParseFloat(final.GridProfit, 64)
+	if err == nil {
+		c.RealizedPnl = realized
+	}
+	c.FundingFee, _ = strconv.ParseFloat(final.FundingFee, 64)
+	c.Fee, _ = strconv.ParseFloat(final.UnmatchedFee, 64)
+	c.Pnl = c.RealizedPnl + c.FundingFee
+	if c.InitialValue != 0 {
+		c.Roi = c.Pnl / c.InitialValue
+	}
+	if final.UpdateTime != 0 {
+		c.ClosedAt = time.UnixMilli(final.UpdateTime)
+		c.HoldTime = int64(c.ClosedAt.Sub(c.OpenedAt).Seconds())
+	}
+}
+
+func (c *ClosedGrid) insert() {
+	err := sql.SimpleTransaction(func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(),
+			`INSERT INTO bts.closed_grid (gid, strategy_id, symbol, direction, initial_value, leverage,
+                             realized_pnl, pnl, roi, funding_fee, fee, exit_rule, exit_reasons,
+                             opened_at, closed_at, hold_time)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16) ON CONFLICT (gid) DO NOTHING`,
+			c.GID, c.SID, c.Symbol, c.Direction, c.InitialValue, c.Leverage,
+			c.RealizedPnl, c.Pnl, c.Roi, c.FundingFee, c.Fee, c.ExitRule, c.ExitReasons,
+			c.OpenedAt, c.ClosedAt, c.HoldTime)
+		return err
+	})
+	if err != nil {
+		discord.Errorf("Error inserting closed grid: %v", err)
+	}
+}
+
+// updateSettled overwrites the outcome recorded when we cancelled the grid with the settled one
+func (c *ClosedGrid) updateSettled() {
+	_, err := sql.GetDB().Exec(context.Background(),
+		`UPDATE bts.closed_grid SET realized_pnl = $2, pnl = $3, roi = $4, funding_fee = $5, fee = $6,
+                            closed_at = $7, hold_time = $8 WHERE gid = $1`,
+		c.GID, c.RealizedPnl, c.Pnl, c.Roi, c.FundingFee, c.Fee, c.ClosedAt, c.HoldTime)
+	if err != nil {
+		discord.Errorf("Error settling closed grid %d: %v", c.GID, err)
+	}
+}
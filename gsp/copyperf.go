@@ -0,0 +1,73 @@
+package gsp
+
+import (
+	"BinanceTopStrategies/cache"
+	"BinanceTopStrategies/config"
+	"BinanceTopStrategies/sql"
+	"context"
+	"fmt"
+	"time"
+)
+
+const AllSymbols = "ALL"
+
+// CopyPerformance is how copying a leader actually worked for us, per symbol or over all symbols (AllSymbols)
+type CopyPerformance struct {
+	UserID         int64     `db:"user_id"`
+	Symbol         string    `db:"symbol"`
+	Copies         int       `db:"copies"`
+	Wins           int       `db:"wins"`
+	AvgRoi         float64   `db:"avg_roi"`
+	TotalRoi       float64   `db:"total_roi"`
+	AvgHoldTime    float64   `db:"avg_hold_time"`
+	LastClosedAt   time.Time `db:"last_closed_at"`
+	LastExitReason string    `db:"last_exit_reason"`
+}
+
+func newCopyPerformanceCache() *cache.Cache[map[string]*CopyPerformance] {
+	return cache.CreateCache[map[string]*CopyPerformance]("copy_performance", 5*time.Minute,
+		func() (map[string]*CopyPerformance, error) {
+			performances := make([]*CopyPerformance, 0)
+			err := sql.GetDB().Scan(&performances, `SELECT * FROM bts.CopyPerformance`)
+			if err != nil {
+				return nil, err
+			}
+			byKey := make(map[string]*CopyPerformance)
+			for _, p := range performances {
+				byKey[copyPerformanceKey(int(p.UserID), p.Symbol)] = p
+			}
+			return byKey, nil
+		})
+}
+
+func copyPerformanceKey(userID int, symbol string) string {
+	return fmt.Sprintf("%d-%s", userID, symbol)
+}
+
+func RefreshCopyPerformance() error {
+	_, err := sql.GetDB().Exec(context.Background(), `REFRESH MATERIALIZED VIEW bts.CopyPerformance`)
+	return err
+}
+
+// GetCopyPerformance returns nil when we never closed a copy of the leader on symbol
+func (e *Engine) GetCopyPerformance(userID int, symbol string) (*CopyPerformance, error) {
+	performances, err := e.CopyPerformance.Get()
+	if err != nil {
+		return nil, err
+	}
+	return performances[copyPerformanceKey(userID, symbol)], nil
+}
+
+// IsLosing tells if there are enough closed copies to judge and they lose money on average
+func (p *CopyPerformance) IsLosing() bool {
+	return p != nil && p.Copies >= config.TheConfig.CopyPerfMinCopies && p.AvgRoi < config.TheConfig.CopyPerfMinAvgRoi
+}
+
+func (p *CopyPerformance) String() string {
+	if p == nil {
+		return ""
+	}
+	return fmt.Sprintf("Copies %s: %d/%d, Avg: %.2f%%, Hold: %s, Last: %s",
+		p.Symbol, p.Wins, p.Copies, p.AvgRoi*100,
+		(time.Duration(p.AvgHoldTime) * time.Second).Round(time.Minute), p.LastExitReason)
+}
@@ -0,0 +1,74 @@
+package gsp
+
+import (
+	"BinanceTopStrategies/clock"
+	"BinanceTopStrategies/config"
+	"BinanceTopStrategies/sql"
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+// CrawlCoverage is what a group of queries of the crawl plan brought in, Unique only counts strategies
+// no earlier group found, so a source that never adds anything can be dropped from the plan
+type CrawlCoverage struct {
+	Source  string
+	Queries int
+	Fetched int
+	Unique  int
+	Capped  int // queries that returned as many rows as asked, likely missing some
+}
+
+func (c *CrawlCoverage) String() string {
+	return fmt.Sprintf("%s: %d queries, %d fetched, %d unique, %d capped", c.Source, c.Queries, c.Fetched, c.Unique, c.Capped)
+}
+
+// crawlPlan covers every sort key over the runtime buckets, then every sort key per direction and per watched symbol
+func crawlPlan(sType int) []StrategyQuery {
+	var queries []StrategyQuery
+	bucket := config.TheConfig.CrawlBucketHours
+	maxHours := config.TheConfig.CrawlMaxHours
+	if bucket <= 0 {
+		bucket = maxHours
+	}
+	for _, sort := range config.TheConfig.CrawlSorts {
+		for i := 0; i < maxHours; i += bucket {
+			queries = append(queries, StrategyQuery{Type: sType, Sort: sort, Source: sort,
+				RuntimeMin: time.Duration(i) * time.Hour, RuntimeMax: time.Duration(i+bucket) * time.Hour})
+		}
+	}
+	for _, d := range config.TheConfig.CrawlDirections {
+		direction, ok := DirectionSMap[d]
+		if !ok {
+			continue
+		}
+		for _, sort := range config.TheConfig.CrawlSorts {
+			queries = append(queries, StrategyQuery{Type: sType, Sort: sort, Source: sort + "/" + d,
+				Direction: &direction, RuntimeMax: time.Duration(maxHours) * time.Hour})
+		}
+	}
+	for _, symbol := range config.TheConfig.CrawlSymbols {
+		for _, sort := range config.TheConfig.CrawlSorts {
+			queries = append(queries, StrategyQuery{Type: sType, Sort: sort, Source: sort + "/" + symbol,
+				Symbol: symbol, RuntimeMax: time.Duration(maxHours) * time.Hour})
+		}
+	}
+	return queries
+}
+
+func recordScrape(sType int, coverage []*CrawlCoverage, total int) error {
+	now := clock.Now()
+	return sql.SimpleTransaction(func(tx pgx.Tx) error {
+		for _, c := range coverage {
+			_, err := tx.Exec(context.Background(),
+				`INSERT INTO bts.scrape (time, strategy_type, source, queries, fetched, unique_new, capped, total_unique)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+				now, sType, c.Source, c.Queries, c.Fetched, c.Unique, c.Capped, total)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -11,12 +11,19 @@ import (
 func Scrape(sType int, sString string) error {
 	t := time.Now()
 	discord.Infof("### Strategies %s: %v", sString, time.Now().Format("2006-01-02 15:04:05"))
-	strategies, err := getTopStrategies(sType)
+	strategies, coverage, err := getTopStrategies(sType)
 	if err != nil {
 		discord.Errorf("Strategies %s: %v", sString, err)
 		return err
 	}
 	discord.Infof("Fetched strategies: %d", len(strategies))
+	for _, c := range coverage {
+		log.Infof("Coverage %s", c)
+	}
+	err = recordScrape(sType, coverage, len(strategies))
+	if err != nil {
+		discord.Errorf("Scrape coverage %s: %v", sString, err)
+	}
 	err = addToRankingStore(strategies)
 	if err != nil {
 		discord.Errorf("Strategies %s: %v", sString, err)
@@ -26,13 +33,14 @@ func Scrape(sType int, sString string) error {
 	return nil
 }
 
-func IsGridOriStrategyRunning(grid *Grid) (*Strategy, error) {
+func (e *Engine) IsGridOriStrategyRunning(grid *Grid) (*Strategy, error) {
 	oriSID := grid.SID
 	var oriUid int
+	var rois StrategyRoi
 	err := sql.GetDB().ScanOne(&oriUid, `SELECT user_id FROM bts.strategy WHERE strategy_id = $1`,
 		oriSID)
 	if err == nil {
-		rois, err := RoisCache.Get(fmt.Sprintf("%d-%d", oriSID, oriUid))
+		rois, err = e.Rois.Get(fmt.Sprintf("%d-%d", oriSID, oriUid))
 		if err != nil {
 			return nil, err
 		}
@@ -40,8 +48,15 @@ func IsGridOriStrategyRunning(grid *Grid) (*Strategy, error) {
 			return nil, nil
 		}
 	}
-	discoverStrategy, err := DiscoverRootStrategy(oriSID, grid.Symbol, DirectionSMap[grid.Direction], grid.GetRunTime())
+	discoverStrategy, err := e.DiscoverRootStrategy(oriSID, grid.Symbol, DirectionSMap[grid.Direction], grid.GetRunTime())
 	if err != nil {
+		// the roi chart is recent enough to keep a pool strategy running until the index answers again
+		if s := e.Pool.FindSID(oriSID); s != nil && len(rois) > 0 {
+			discord.Errorf("Error discovering strategy %d, running by its roi chart: %v", oriSID, err)
+			found := *s
+			found.Rois = rois
+			return &found, nil
+		}
 		return nil, err
 	}
 	if discoverStrategy != nil {
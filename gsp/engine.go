@@ -0,0 +1,102 @@
+package gsp
+
+import (
+	"BinanceTopStrategies/account"
+	"BinanceTopStrategies/cache"
+	"BinanceTopStrategies/config"
+	"BinanceTopStrategies/sdk"
+	"BinanceTopStrategies/utils"
+	"fmt"
+	mapset "github.com/deckarep/golang-set/v2"
+	"strconv"
+)
+
+// Engine is the state a tick works on: the pool and caches shared by the session of every account
+type Engine struct {
+	Pool            Strategies
+	Rois            *cache.MapCache[StrategyRoi]
+	WL              *cache.MapCache[UserWL]
+	PrecomputedWL   *cache.Cache[map[int]UserWL]
+	CopyPerformance *cache.Cache[map[string]*CopyPerformance]
+	Timer           *utils.Timer
+	Sessions        []*Session
+	roots           *rootIndex
+}
+
+// Session is the trading state of one account, kept from one tick to the next
+type Session struct {
+	*Engine
+	Account       *account.Account
+	Prices        *sdk.PriceSnapshot
+	CancelledGIDs mapset.Set[int]
+	openGrids     Grids
+}
+
+func NewEngine(accounts ...*account.Account) *Engine {
+	precomputedWL := newPrecomputedWLCache()
+	e := &Engine{
+		Rois:            newRoisCache(),
+		WL:              newUserWLCache(precomputedWL),
+		PrecomputedWL:   precomputedWL,
+		CopyPerformance: newCopyPerformanceCache(),
+		Timer:           utils.NewTimer(),
+		roots:           newRootIndex(),
+	}
+	for _, acc := range accounts {
+		e.Sessions = append(e.Sessions, &Session{
+			Engine:        e,
+			Account:       acc,
+			Prices:        sdk.NewPriceSnapshot(),
+			CancelledGIDs: mapset.NewSet[int](),
+		})
+	}
+	return e
+}
+
+// StartTick drops the running strategies of the previous tick
+func (e *Engine) StartTick() {
+	e.roots = newRootIndex()
+	e.Timer.Reset()
+}
+
+// RefreshPrices starts a new price snapshot for the account, the prices already served stay with their grids
+// and strategies
+func (session *Session) RefreshPrices() {
+	session.Prices = sdk.NewPriceSnapshot()
+}
+
+func (e *Engine) SetPool(strategies Strategies) {
+	e.Pool = strategies
+}
+
+// PrefetchPool warms the WL of every pool user and the rois of every pool strategy
+func (e *Engine) PrefetchPool(pool []*ChosenStrategyDB) {
+	users := mapset.NewSet[string]()
+	rois := make([]string, 0, len(pool))
+	for _, s := range pool {
+		users.Add(strconv.FormatInt(s.UserID, 10))
+		rois = append(rois, fmt.Sprintf("%d-%d", s.StrategyID, s.UserID))
+	}
+	e.WL.Prefetch(users.ToSlice(), config.TheConfig.CachePrefetchConcurrency)
+	e.Rois.Prefetch(rois, config.TheConfig.CachePrefetchConcurrency)
+}
+
+func (e *Engine) GetUserWL(userId int) (UserWL, error) {
+	return e.WL.Get(strconv.Itoa(userId))
+}
+
+func (e *Engine) PopulateRois(s *Strategy) error {
+	rois, err := e.Rois.Get(fmt.Sprintf("%d-%d", s.SID, s.UserID))
+	if err != nil {
+		return err
+	}
+	s.Rois = rois
+	if len(s.Rois) > 1 {
+		s.Roi = s.Rois[0].Roi
+	}
+	return nil
+}
+
+func (session *Session) OpenGrids() Grids {
+	return session.openGrids
+}
@@ -0,0 +1,82 @@
+package gsp
+
+import (
+	"BinanceTopStrategies/clock"
+	"BinanceTopStrategies/config"
+	"BinanceTopStrategies/discord"
+	"BinanceTopStrategies/sql"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+type followUpPair struct {
+	Symbol    string `db:"symbol"`
+	Direction int    `db:"direction"`
+}
+
+// FollowUp looks for new strategies of TheChosen users on the pairs they traded recently or still trade,
+// so they are found shortly after creation instead of whenever they reach the top lists
+func FollowUp() error {
+	t := time.Now()
+	pairs := make([]*followUpPair, 0)
+	err := sql.GetDB().Scan(&pairs,
+		`SELECT DISTINCT s.symbol, s.direction
+		FROM bts.strategy s
+		         JOIN bts.TheChosen c ON c.user_id = s.user_id
+		WHERE s.strategy_type = $1
+		  AND (s.concluded IS NOT TRUE OR s.time_discovered >= $2)`,
+		FUTURE, clock.Now().AddDate(0, 0, -config.TheConfig.FollowUpLookbackDays))
+	if err != nil {
+		return err
+	}
+	var chosen []int
+	err = sql.GetDB().Scan(&chosen, `SELECT user_id FROM bts.TheChosen`)
+	if err != nil {
+		return err
+	}
+	users := make(map[int]bool)
+	for _, u := range chosen {
+		users[u] = true
+	}
+	found := make(Strategies, 0)
+	total := &CrawlCoverage{Source: "followup"}
+	failed := 0
+	for _, p := range pairs {
+		direction := p.Direction
+		strategies, coverage, err := crawlStrategies(StrategyQuery{Type: FUTURE, Sort: SortByPnl, Source: "followup",
+			Symbol: p.Symbol, Direction: &direction,
+			RuntimeMax: time.Duration(config.TheConfig.FollowUpMaxRuntimeMinutes) * time.Minute,
+			Count:      config.TheConfig.FollowUpCount})
+		if err != nil {
+			log.Warnf("Follow up of %s %s: %v", p.Symbol, DirectionMap[p.Direction], err)
+			failed++
+			continue
+		}
+		for _, c := range coverage {
+			total.Queries += c.Queries
+			total.Fetched += c.Fetched
+			total.Unique += c.Unique
+			total.Capped += c.Capped
+		}
+		for _, s := range strategies {
+			if users[s.UserID] {
+				found = append(found, s)
+			}
+		}
+	}
+	if failed > 0 {
+		discord.Errorf("Follow up failed for %d/%d pairs", failed, len(pairs))
+	}
+	err = recordScrape(FUTURE, []*CrawlCoverage{total}, len(found))
+	if err != nil {
+		discord.Errorf("Follow up coverage: %v", err)
+	}
+	if len(found) > 0 {
+		err = addToRankingStore(found)
+		if err != nil {
+			return err
+		}
+	}
+	discord.Infof("*Follow up of %d pairs found %d strategies, took: %v*", len(pairs), len(found), time.Since(t))
+	return nil
+}
@@ -4,22 +4,14 @@ import (
 	mapset "github.com/deckarep/golang-set/v2"
 )
 
-var SessionCancelledGIDs = mapset.NewSet[int]()
-
 type Grids []*Grid
 
-var openGrids Grids
-
 type TotalProfit struct {
 	Input float64
 	Pnl   float64
 	Roi   float64
 }
 
-func GetOpenGrids() Grids {
-	return openGrids
-}
-
 func (grids Grids) AllSymbols() mapset.Set[string] {
 	symbols := mapset.NewSet[string]()
 	for _, g := range grids {
@@ -99,16 +91,6 @@ func (grids Grids) TotalProfitByQuote(quote string) TotalProfit {
 
 type Strategies []*Strategy
 
-var pool Strategies
-
-func GetPool() Strategies {
-	return pool
-}
-
-func SetPool(strategies Strategies) {
-	pool = strategies
-}
-
 func (by Strategies) ByUID() map[int]Strategies {
 	byUID := make(map[int]Strategies)
 	for _, s := range by {
@@ -1,6 +1,7 @@
 package gsp
 
 import (
+	"BinanceTopStrategies/clock"
 	"BinanceTopStrategies/discord"
 	"BinanceTopStrategies/sdk"
 	"BinanceTopStrategies/sql"
@@ -17,11 +18,13 @@ type Grid struct {
 	LastRoi                float64
 	LastRealizedRoi        float64
 	LastRealizedPnl        float64
+	MarketPrice            float64 `json:"-"`
 	BelowLowerLimit        bool
 	AboveUpperLimit        bool
 	Lowest                 *GridDB
 	Highest                *GridDB
 	GID                    int    `json:"strategyId"`
+	ClientStrategyID       string `json:"clientStrategyId"`
 	RootUserID             int    `json:"rootUserId"`
 	StrategyUserID         int    `json:"strategyUserId"`
 	StrategyAccountID      int    `json:"strategyAccountId"`
@@ -68,13 +71,14 @@ type GridDB struct {
 	Time        time.Time `db:"time"`
 }
 
-func (grid *Grid) sanitize() {
+func (grid *Grid) sanitize(prices *sdk.PriceSnapshot) {
 	initial, _ := strconv.ParseFloat(grid.GridInitialValue, 64)
 	grid.LastRealizedPnl, _ = strconv.ParseFloat(grid.GridProfit, 64)
 	fundingFee, _ := strconv.ParseFloat(grid.FundingFee, 64)
 	position, _ := strconv.ParseFloat(grid.GridPosition, 64)
 	entryPrice, _ := strconv.ParseFloat(grid.GridEntryPrice, 64)
-	marketPrice, _ := sdk.GetSessionSymbolPrice(grid.Symbol)
+	marketPrice, _ := prices.Get(grid.Symbol)
+	grid.MarketPrice = marketPrice
 	lowerLimit, _ := strconv.ParseFloat(grid.GridLowerLimit, 64)
 	upperLimit, _ := strconv.ParseFloat(grid.GridUpperLimit, 64)
 	grid.BelowLowerLimit = marketPrice < lowerLimit
@@ -86,7 +90,7 @@ func (grid *Grid) sanitize() {
 	err := sql.SimpleTransaction(func(tx pgx.Tx) error {
 		_, err := tx.Exec(context.Background(),
 			`INSERT INTO bts.grid (gid, roi, realized_roi, time) VALUES ($1, $2, $3, $4)`,
-			grid.GID, grid.LastRoi, grid.LastRealizedRoi, time.Now())
+			grid.GID, grid.LastRoi, grid.LastRealizedRoi, clock.Now())
 		return err
 	})
 	if err != nil {
@@ -96,7 +100,7 @@ func (grid *Grid) sanitize() {
 }
 
 func (grid *Grid) GetLocalWithin(duration time.Duration) (*GridDB, *GridDB) {
-	earliest := time.Now().Add(-duration)
+	earliest := clock.Now().Add(-duration)
 	lowest := &GridDB{}
 	highest := &GridDB{}
 	err := sql.GetDB().ScanOne(lowest, `SELECT * FROM bts.grid WHERE gid = $1 AND time >= $2 ORDER BY roi LIMIT 1`, grid.GID, earliest)
@@ -130,11 +134,12 @@ func (grid *Grid) IsQuote(quote string) bool {
 }
 
 func (grid *Grid) GetRunTime() time.Duration {
-	return time.Duration(time.Now().Unix()-grid.BookTime/1000) * time.Second
+	return time.Duration(clock.Now().Unix()-grid.BookTime/1000) * time.Second
 }
 
+// MarketPriceWithinRange uses the market price of the tick the grid was fetched in
 func (grid *Grid) MarketPriceWithinRange() bool {
-	marketPrice, _ := sdk.GetSessionSymbolPrice(grid.Symbol)
+	marketPrice := grid.MarketPrice
 	lowerLimit, _ := strconv.ParseFloat(grid.GridLowerLimit, 64)
 	upperLimit, _ := strconv.ParseFloat(grid.GridUpperLimit, 64)
 	return marketPrice > lowerLimit && marketPrice < upperLimit
@@ -143,9 +148,9 @@ func (grid *Grid) MarketPriceWithinRange() bool {
 func (grid *Grid) String() string {
 	extendedProfit := fmt.Sprintf("[%.2f%% (%s), %.2f%% (%s)]",
 		grid.Highest.Roi*100,
-		time.Since(grid.Highest.Time).Round(time.Minute),
+		clock.Since(grid.Highest.Time).Round(time.Minute),
 		grid.Lowest.Roi*100,
-		time.Since(grid.Lowest.Time).Round(time.Minute))
+		clock.Since(grid.Lowest.Time).Round(time.Minute))
 
 	outOfRange := ""
 	if !grid.MarketPriceWithinRange() {
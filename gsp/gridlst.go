@@ -1,11 +1,15 @@
 package gsp
 
 import (
+	"BinanceTopStrategies/account"
 	"BinanceTopStrategies/blacklist"
 	"BinanceTopStrategies/config"
 	"BinanceTopStrategies/discord"
 	"BinanceTopStrategies/request"
 	"BinanceTopStrategies/sql"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
 	"sort"
 	"time"
 )
@@ -15,48 +19,72 @@ type openGridResponse struct {
 	request.BinanceBaseResponse
 }
 
-func getOpenGrids() (*openGridResponse, error) {
-	url := "https://www.binance.com/bapi/futures/v2/private/future/grid/query-open-grids"
-	res, _, err := request.PrivateRequest(url, "POST", nil, &openGridResponse{})
+// unlinkedReportCooldown is how long a grid placed outside of the bot is not reported again
+const unlinkedReportCooldown = 30 * 24 * time.Hour
+
+func getOpenGrids(acc *account.Account) (*openGridResponse, error) {
+	url := request.Url("/bapi/futures/v2/private/future/grid/query-open-grids")
+	res, _, err := request.PrivateRequest(acc, url, "POST", nil, &openGridResponse{})
 	if err != nil {
 		return nil, err
 	}
 	for _, grid := range res.Grids {
 		id := 0
 		err = sql.GetDB().ScanOne(&id, "SELECT strategy_id FROM bts.grid_strategy WHERE grid_id=$1", grid.GID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			id, err = reconcileLink(acc, grid)
+			if err == nil && id == 0 && blacklist.Cooldown(acc.BlacklistNamespace, fmt.Sprintf("unlinked:%d", grid.GID),
+				unlinkedReportCooldown, "unlinked grid reported") {
+				discord.Errorf("Grid %d %s has no strategy link and no pending order, left alone", grid.GID, grid.Symbol)
+			}
+		}
 		if err != nil {
 			discord.Errorf("Error getting strategy id for grid %d: %v", grid.GID, err)
 			return nil, err
 		}
 		grid.SID = id
 	}
+	expirePendingOrders(acc)
 	return res, nil
 }
 
-func UpdateOpenGrids() error {
-	res, err := getOpenGrids()
+func (session *Session) UpdateOpenGrids() error {
+	res, err := getOpenGrids(session.Account)
 	if err != nil {
 		return err
 	}
 	for _, grid := range res.Grids {
-		grid.sanitize()
+		grid.sanitize(session.Prices)
 	}
-	for _, g := range openGrids { // previous grids
+	history := newGridHistory(session.Account)
+	for _, g := range session.openGrids { // previous grids
 		if res.Grids.FindGID(g.GID) == nil {
-			if !SessionCancelledGIDs.Contains(g.GID) {
-				discord.Actionf(Display(nil, g,
+			final, err := history.find(g.GID, g.BookTime)
+			if err != nil {
+				discord.Errorf("Error getting grid history: %v", err)
+			}
+			if !session.CancelledGIDs.Contains(g.GID) {
+				discord.Actionf(session.Display(nil, g,
 					"**Gone**",
 					0, 0))
+				closed := newClosedGrid(g, ExitGone, []string{"grid gone"})
+				closed.settle(final)
+				closed.insert()
+			} else if final != nil && !session.Account.Paper {
+				// recorded from our snapshot when we cancelled it, binance has settled it since
+				closed := newClosedGrid(g, "", nil)
+				closed.settle(final)
+				closed.updateSettled()
 			}
-			blacklist.BlockTrading(time.Duration(config.TheConfig.TradingBlockMinutesAfterCancel)*time.Minute, "Grid Gone")
+			blacklist.BlockTrading(session.Account.BlacklistNamespace, time.Duration(config.TheConfig.TradingBlockMinutesAfterCancel)*time.Minute, "Grid Gone")
 		}
 	}
-	openGrids = res.Grids
-	sort.Slice(openGrids, func(i, j int) bool {
-		return openGrids[i].GID < openGrids[j].GID
+	session.openGrids = res.Grids
+	sort.Slice(session.openGrids, func(i, j int) bool {
+		return session.openGrids[i].GID < session.openGrids[j].GID
 	})
-	USDT, USDC := openGrids.TotalProfits()
-	long, short, neutral := openGrids.GetLSN()
+	USDT, USDC := session.openGrids.TotalProfits()
+	long, short, neutral := session.openGrids.GetLSN()
 	discord.Infof("USDT[Input: %.2f, PnL: %.2f], USDC[Input: %.2f, PnL: %.2f], L/S/N: %d/%d/%d",
 		USDT.Input, USDT.Pnl, USDC.Input, USDC.Pnl, long, short, neutral)
 	return nil
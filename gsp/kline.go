@@ -0,0 +1,281 @@
+package gsp
+
+import (
+	"BinanceTopStrategies/clock"
+	"BinanceTopStrategies/config"
+	"BinanceTopStrategies/discord"
+	"BinanceTopStrategies/sdk"
+	"BinanceTopStrategies/sql"
+	"context"
+	"fmt"
+	"github.com/adshao/go-binance/v2/futures"
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+)
+
+const (
+	klineInterval = "30m"
+	klineDuration = 30 * time.Minute
+	klineLimit    = 1500
+)
+
+type PriceDB struct {
+	Open      float64   `db:"open"`
+	High      float64   `db:"high"`
+	Low       float64   `db:"low"`
+	Close     float64   `db:"close"`
+	OpenTime  time.Time `db:"open_time"`
+	CloseTime time.Time `db:"close_time"`
+}
+
+var priceColumns = []string{
+	"symbol_id",
+	"open",
+	"high",
+	"low",
+	"close",
+	"volume",
+	"quote_volume",
+	"trade_number",
+	"taker_buy_base_volume",
+	"taker_buy_quote_volume",
+	"open_time",
+	"close_time",
+}
+
+type symbolDB struct {
+	SymbolID   int        `db:"symbol_id"`
+	SymbolName string     `db:"symbol_name"`
+	Latest     *time.Time `db:"latest"`
+}
+
+type klineGap struct {
+	From time.Time `db:"from_time"`
+	To   time.Time `db:"to_time"`
+}
+
+// klineIngestion spends a budget of requests, shared by every symbol of a run
+type klineIngestion struct {
+	requests int
+	inserted int64
+}
+
+func (k *klineIngestion) exhausted() bool {
+	return k.requests >= config.TheConfig.KlineMaxRequestsPerRun
+}
+
+// fetch stores the closed klines of symbol opening in [from, to], page by page
+func (k *klineIngestion) fetch(symbol *symbolDB, from, to time.Time) error {
+	now := clock.Now()
+	for !from.After(to) && !k.exhausted() {
+		k.requests++
+		klines, err := sdk.FuturesClient.NewKlinesService().Symbol(symbol.SymbolName).Interval(klineInterval).
+			StartTime(from.UnixMilli()).EndTime(to.UnixMilli()).Limit(klineLimit).Do(context.Background())
+		if err != nil {
+			return err
+		}
+		rows := make([][]interface{}, 0, len(klines))
+		for _, kl := range klines {
+			if kl.CloseTime >= now.UnixMilli() {
+				continue
+			}
+			row, err := priceRow(symbol.SymbolID, kl)
+			if err != nil {
+				return err
+			}
+			rows = append(rows, row)
+		}
+		err = sql.SimpleTransaction(func(tx pgx.Tx) error {
+			_, err := tx.Exec(context.Background(), `CREATE TEMPORARY TABLE _temp_price (LIKE bts.price INCLUDING ALL) ON COMMIT DROP`)
+			if err != nil {
+				return err
+			}
+			inserted, err := tx.CopyFrom(context.Background(), pgx.Identifier{"_temp_price"}, priceColumns, pgx.CopyFromRows(rows))
+			if err != nil {
+				return err
+			}
+			k.inserted += inserted
+			_, err = tx.Exec(context.Background(), `INSERT INTO bts.price SELECT * FROM _temp_price ON CONFLICT DO NOTHING`)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if len(klines) < klineLimit {
+			return nil
+		}
+		from = time.UnixMilli(klines[len(klines)-1].OpenTime).Add(klineDuration)
+	}
+	return nil
+}
+
+func priceRow(symbolID int, k *futures.Kline) ([]interface{}, error) {
+	values := make([]float64, 0, 8)
+	for _, v := range []string{k.Open, k.High, k.Low, k.Close, k.Volume, k.QuoteAssetVolume,
+		k.TakerBuyBaseAssetVolume, k.TakerBuyQuoteAssetVolume} {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, f)
+	}
+	return []interface{}{symbolID, values[0], values[1], values[2], values[3], values[4], values[5],
+		k.TradeNum, values[6], values[7], time.UnixMilli(k.OpenTime), time.UnixMilli(k.CloseTime)}, nil
+}
+
+// IngestKlines keeps bts.price filled with the 30m klines of every futures symbol seen in bts.strategy,
+// new klines first, then the gaps left by failed or capped runs
+func IngestKlines() error {
+	_, err := sql.GetDB().Exec(context.Background(), `INSERT INTO bts.symbol (symbol_name)
+		SELECT DISTINCT symbol FROM bts.strategy WHERE strategy_type = 2 ON CONFLICT DO NOTHING`)
+	if err != nil {
+		return err
+	}
+	symbols := make([]*symbolDB, 0)
+	err = sql.GetDB().Scan(&symbols, `SELECT s.symbol_id, s.symbol_name, MAX(p.open_time) AS latest
+		FROM bts.symbol s
+		         LEFT JOIN bts.price p ON p.symbol_id = s.symbol_id
+		GROUP BY s.symbol_id, s.symbol_name
+		ORDER BY latest NULLS FIRST`)
+	if err != nil {
+		return err
+	}
+	k := &klineIngestion{}
+	backfillFrom := klineBackfillFrom()
+	failed := 0
+	for _, s := range symbols {
+		if k.exhausted() {
+			break
+		}
+		from := backfillFrom
+		if s.Latest != nil {
+			from = s.Latest.Add(klineDuration)
+		}
+		err = k.fetch(s, from, clock.Now())
+		if err != nil {
+			log.Errorf("Error fetching klines of %s: %v", s.SymbolName, err)
+			failed++
+		}
+	}
+	gapCount := 0
+	for _, s := range symbols {
+		if k.exhausted() {
+			break
+		}
+		gaps, err := findKlineGaps(s.SymbolID, backfillFrom)
+		if err != nil {
+			return err
+		}
+		for _, g := range gaps {
+			gapCount++
+			err = k.fetch(s, g.From, g.To)
+			if err != nil {
+				log.Errorf("Error backfilling klines of %s: %v", s.SymbolName, err)
+				failed++
+				break
+			}
+		}
+	}
+	discord.Infof("Ingested %d klines of %d symbols, %d gaps, %d requests, %d failed",
+		k.inserted, len(symbols), gapCount, k.requests, failed)
+	return nil
+}
+
+// findKlineGaps finds the missing klines between the stored ones since the start of the window, including
+// before the first one, which costs a request per run for symbols listed within the window
+func findKlineGaps(symbolID int, since time.Time) ([]*klineGap, error) {
+	gaps := make([]*klineGap, 0)
+	err := sql.GetDB().Scan(&gaps, `SELECT $2::TIMESTAMP WITH TIME ZONE AS from_time, MIN(open_time) - INTERVAL '30 minutes' AS to_time
+		FROM bts.price
+		WHERE symbol_id = $1
+		  AND open_time >= $2
+		HAVING MIN(open_time) > $2
+		UNION ALL
+		SELECT open_time + INTERVAL '30 minutes' AS from_time, next_open - INTERVAL '30 minutes' AS to_time
+		FROM (SELECT open_time, LEAD(open_time) OVER (ORDER BY open_time) AS next_open
+		      FROM bts.price
+		      WHERE symbol_id = $1
+		        AND open_time >= $2) p
+		WHERE next_open - open_time > INTERVAL '30 minutes'`, symbolID, since)
+	return gaps, err
+}
+
+// klineBackfillFrom is the start of the window IngestKlines keeps in bts.price
+func klineBackfillFrom() time.Time {
+	return clock.Now().Add(-time.Duration(config.TheConfig.KlineBackfillDays) * 24 * time.Hour).Truncate(klineDuration)
+}
+
+// fetchPrices reads the closed klines of symbol opening in [from, to] from binance, for the ranges
+// that start before the window of bts.price
+func fetchPrices(symbol string, from, to time.Time) ([]*PriceDB, error) {
+	now := clock.Now()
+	prices := make([]*PriceDB, 0)
+	for !from.After(to) {
+		klines, err := sdk.FuturesClient.NewKlinesService().Symbol(symbol).Interval(klineInterval).
+			StartTime(from.UnixMilli()).EndTime(to.UnixMilli()).Limit(klineLimit).Do(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for _, kl := range klines {
+			if kl.CloseTime >= now.UnixMilli() {
+				continue
+			}
+			values := make([]float64, 0, 4)
+			for _, v := range []string{kl.Open, kl.High, kl.Low, kl.Close} {
+				f, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, f)
+			}
+			prices = append(prices, &PriceDB{Open: values[0], High: values[1], Low: values[2], Close: values[3],
+				OpenTime: time.UnixMilli(kl.OpenTime), CloseTime: time.UnixMilli(kl.CloseTime)})
+		}
+		if len(klines) < klineLimit {
+			break
+		}
+		from = time.UnixMilli(klines[len(klines)-1].OpenTime).Add(klineDuration)
+	}
+	return prices, nil
+}
+
+// GetPrices computes the price metrics of a strategy running from timeStart to timeEnd (millis)
+// out of the stored 30m klines, from the one opening 30 minutes before the start to the one opening at the end,
+// or out of klines fetched from binance when the range starts before the stored window.
+// The definition of the metrics is picked by config.PriceVersion
+func GetPrices(symbol string, timeStart int64, timeEnd int64) (*PriceMetrics, error) {
+	compute, ok := priceVersions[config.TheConfig.PriceVersion]
+	if !ok {
+		return nil, fmt.Errorf("price version %d not registered", config.TheConfig.PriceVersion)
+	}
+	if timeStart == timeEnd {
+		timeEnd = timeStart + 3600*1000
+	}
+	start := time.UnixMilli(timeStart)
+	end := time.UnixMilli(timeEnd)
+	prices := make([]*PriceDB, 0)
+	var err error
+	if start.Add(-klineDuration).Before(klineBackfillFrom()) {
+		prices, err = fetchPrices(symbol, start.Add(-klineDuration), end)
+	} else {
+		err = sql.GetDB().Scan(&prices, `SELECT p.open, p.high, p.low, p.close, p.open_time, p.close_time
+			FROM bts.price p
+			         JOIN bts.symbol s ON s.symbol_id = p.symbol_id
+			WHERE s.symbol_name = $1
+			  AND p.open_time >= $2
+			  AND p.open_time <= $3
+			ORDER BY p.open_time`, symbol, start.Add(-klineDuration), end)
+	}
+	if err != nil {
+		return nil, err
+	}
+	metrics, err := compute(prices, start, end)
+	if err != nil {
+		return nil, err
+	}
+	version := config.TheConfig.PriceVersion
+	metrics.PriceVersion = &version
+	return metrics, nil
+}
@@ -0,0 +1,163 @@
+package gsp
+
+import (
+	"BinanceTopStrategies/account"
+	"BinanceTopStrategies/clock"
+	"BinanceTopStrategies/config"
+	"BinanceTopStrategies/discord"
+	"BinanceTopStrategies/sql"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"hash/fnv"
+	"time"
+)
+
+const (
+	PendingStatus  = "pending" // written before the place call, the outcome is unknown
+	PlacedStatus   = "placed"  // linked to its grid
+	FailedStatus   = "failed"  // binance answered with an error
+	ExpiredStatus  = "expired" // never showed up in the open grids
+	clientIDPrefix = "ctrc_web_"
+)
+
+type PendingOrder struct {
+	ClientStrategyID string    `db:"client_strategy_id"`
+	Account          string    `db:"account"`
+	StrategyID       int       `db:"strategy_id"`
+	Symbol           string    `db:"symbol"`
+	Direction        string    `db:"direction"`
+	LowerLimit       string    `db:"lower_limit"`
+	UpperLimit       string    `db:"upper_limit"`
+	GridCount        int       `db:"grid_count"`
+	Leverage         int       `db:"leverage"`
+	Policy           string    `db:"policy"`
+	Status           string    `db:"status"`
+	GridID           *int      `db:"grid_id"`
+	CreatedAt        time.Time `db:"created_at"`
+}
+
+// clientStrategyID is the same for every retry of one placement attempt of sid on an account,
+// it moves on once an attempt is settled so the strategy can be placed again later
+func clientStrategyID(acc *account.Account, sid int) (string, error) {
+	var attempt int
+	err := sql.GetDB().ScanOne(&attempt, `SELECT COUNT(*) FROM bts.pending_order
+		WHERE account = $1 AND strategy_id = $2 AND status <> $3`, acc.Name, sid, PendingStatus)
+	if err != nil {
+		return "", err
+	}
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%s|%d|%d", acc.Name, sid, attempt)
+	return fmt.Sprintf("%s%019d", clientIDPrefix, h.Sum64()%1e19), nil
+}
+
+func newPendingOrder(acc *account.Account, strategy Strategy, payload *placeGridRequest) (*PendingOrder, error) {
+	clientID, err := clientStrategyID(acc, strategy.SID)
+	if err != nil {
+		return nil, err
+	}
+	order := &PendingOrder{
+		ClientStrategyID: clientID,
+		Account:          acc.Name,
+		StrategyID:       strategy.SID,
+		Symbol:           payload.Symbol,
+		Direction:        payload.Direction,
+		LowerLimit:       payload.GridLowerLimit,
+		UpperLimit:       payload.GridUpperLimit,
+		GridCount:        payload.GridCount,
+		Leverage:         payload.Leverage,
+		Policy:           strategy.GridPolicy,
+		Status:           PendingStatus,
+		CreatedAt:        clock.Now(),
+	}
+	_, err = sql.GetDB().Exec(context.Background(),
+		`INSERT INTO bts.pending_order (client_strategy_id, account, strategy_id, symbol, direction, lower_limit,
+                               upper_limit, grid_count, leverage, policy, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (client_strategy_id) DO UPDATE
+		SET symbol = EXCLUDED.symbol,
+		    lower_limit = EXCLUDED.lower_limit,
+		    upper_limit = EXCLUDED.upper_limit,
+		    grid_count = EXCLUDED.grid_count,
+		    leverage = EXCLUDED.leverage,
+		    policy = EXCLUDED.policy,
+		    created_at = EXCLUDED.created_at`,
+		order.ClientStrategyID, order.Account, order.StrategyID, order.Symbol, order.Direction, order.LowerLimit,
+		order.UpperLimit, order.GridCount, order.Leverage, order.Policy, order.Status, order.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error writing pending order: %w", err)
+	}
+	return order, nil
+}
+
+// link ties the order to its grid, both in grid_strategy and in pending_order
+func (order *PendingOrder) link(gid int) error {
+	return sql.SimpleTransaction(func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(), `INSERT INTO bts.grid_strategy (strategy_id, grid_id, policy)
+			VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`,
+			order.StrategyID, gid, order.Policy)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(context.Background(), `UPDATE bts.pending_order SET status = $1, grid_id = $2
+			WHERE client_strategy_id = $3`, PlacedStatus, gid, order.ClientStrategyID)
+		return err
+	})
+}
+
+func (order *PendingOrder) fail() {
+	_, err := sql.GetDB().Exec(context.Background(),
+		`UPDATE bts.pending_order SET status = $1 WHERE client_strategy_id = $2 AND status = $3`,
+		FailedStatus, order.ClientStrategyID, PendingStatus)
+	if err != nil {
+		discord.Errorf("Error failing pending order %s: %v", order.ClientStrategyID, err)
+	}
+}
+
+// findPendingOrder matches an unlinked grid by its client id, or else by symbol and params
+func findPendingOrder(acc *account.Account, grid *Grid) (*PendingOrder, error) {
+	order := &PendingOrder{}
+	err := sql.GetDB().ScanOne(order, `SELECT * FROM bts.pending_order
+		WHERE account = $1 AND (grid_id IS NULL OR grid_id = $2)
+		  AND (client_strategy_id = $3
+		   OR (status = $4 AND symbol = $5 AND direction = $6 AND lower_limit::NUMERIC = $7::NUMERIC
+		       AND upper_limit::NUMERIC = $8::NUMERIC AND grid_count = $9))
+		ORDER BY client_strategy_id = $3 DESC, created_at DESC
+		LIMIT 1`,
+		acc.Name, grid.GID, grid.ClientStrategyID, PendingStatus, grid.Symbol, grid.Direction,
+		grid.GridLowerLimit, grid.GridUpperLimit, grid.GridCount)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// reconcileLink repairs the grid_strategy link of a grid from its pending order, 0 when there is none to repair from
+func reconcileLink(acc *account.Account, grid *Grid) (int, error) {
+	order, err := findPendingOrder(acc, grid)
+	if err != nil || order == nil {
+		return 0, err
+	}
+	err = order.link(grid.GID)
+	if err != nil {
+		return 0, err
+	}
+	discord.Infof("Repaired link of grid %d to strategy %d from pending order %s", grid.GID, order.StrategyID,
+		order.ClientStrategyID)
+	return order.StrategyID, nil
+}
+
+// expirePendingOrders settles the orders that never showed up in the open grids
+func expirePendingOrders(acc *account.Account) {
+	_, err := sql.GetDB().Exec(context.Background(),
+		`UPDATE bts.pending_order SET status = $1 WHERE account = $2 AND status = $3 AND created_at < $4`,
+		ExpiredStatus, acc.Name, PendingStatus,
+		clock.Now().Add(-time.Duration(config.TheConfig.PendingOrderExpiryMinutes)*time.Minute))
+	if err != nil {
+		discord.Errorf("Error expiring pending orders: %v", err)
+	}
+}
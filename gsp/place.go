@@ -1,15 +1,13 @@
 package gsp
 
 import (
+	"BinanceTopStrategies/account"
 	"BinanceTopStrategies/config"
 	"BinanceTopStrategies/discord"
 	"BinanceTopStrategies/request"
-	"BinanceTopStrategies/sql"
 	"BinanceTopStrategies/utils"
-	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/jackc/pgx/v5"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -50,7 +48,7 @@ type placeGridResponse struct {
 	request.BinanceBaseResponse
 }
 
-func PlaceGrid(strategy Strategy, input float64, leverage int, useCopy bool) error {
+func PlaceGrid(acc *account.Account, strategy Strategy, input float64, leverage int, useCopy bool) error {
 	if _, ok := DirectionMap[strategy.Direction]; !ok {
 		return fmt.Errorf("invalid direction: %d", strategy.Direction)
 	}
@@ -69,7 +67,6 @@ func PlaceGrid(strategy Strategy, input float64, leverage int, useCopy bool) err
 		TrailingUp:             strategy.StrategyParams.TrailingUp,
 		TrailingDown:           strategy.StrategyParams.TrailingDown,
 		OrderCurrency:          "BASE",
-		ClientStrategyID:       "ctrc_web_" + utils.GenerateRandomNumberUUID(),
 		TrailingStopLowerLimit: false, // !!t[E.w2.stopLowerLimit]
 		TrailingStopUpperLimit: false, // !1 in js
 	}
@@ -94,22 +91,31 @@ func PlaceGrid(strategy Strategy, input float64, leverage int, useCopy bool) err
 		payload.StopLowerLimit = *strategy.StrategyParams.StopLowerLimit
 		payload.StopTriggerType = "MARK_PRICE"
 	}
-	s, _ := json.Marshal(payload)
-	discord.Orderf(discord.Json(string(s)))
-	if config.TheConfig.Paper {
+	if acc.Paper {
+		payload.ClientStrategyID = clientIDPrefix + utils.GenerateRandomNumberUUID()
+		s, _ := json.Marshal(payload)
+		discord.Orderf(discord.Json(string(s)))
 		log.Infof("Paper mode, not placing grid")
 		return nil
 	}
-	resp, _, err := request.PrivateRequest("https://www.binance.com/bapi/futures/v2/private/future/grid/place-grid", "POST", payload, &placeGridResponse{})
-	if err == nil {
-		err = sql.SimpleTransaction(func(tx pgx.Tx) error {
-			_, err = tx.Exec(context.Background(), `INSERT INTO bts.grid_strategy (strategy_id, grid_id) VALUES ($1, $2)`,
-				strategy.SID, resp.Data.StrategyID)
-			return err
-		})
-		if err != nil {
-			discord.Errorf("Error inserting grid_strategy: %v", err)
+	order, err := newPendingOrder(acc, strategy, payload)
+	if err != nil {
+		return err
+	}
+	payload.ClientStrategyID = order.ClientStrategyID
+	s, _ := json.Marshal(payload)
+	discord.Orderf(discord.Json(string(s)))
+	resp, _, err := request.PrivateRequest(acc, request.Url("/bapi/futures/v2/private/future/grid/place-grid"), "POST", payload, &placeGridResponse{})
+	if err != nil {
+		if resp != nil && resp.Code != nil { // binance answered, nothing was placed
+			order.fail()
 		}
+		return err
+	}
+	err = order.link(resp.Data.StrategyID)
+	if err != nil {
+		// the grid is placed, the next open grids update links it from the pending order
+		discord.Errorf("Error linking grid %d to strategy %d: %v", resp.Data.StrategyID, strategy.SID, err)
 	}
-	return err
+	return nil
 }
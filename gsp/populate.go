@@ -0,0 +1,258 @@
+package gsp
+
+import (
+	"BinanceTopStrategies/clock"
+	"BinanceTopStrategies/config"
+	"BinanceTopStrategies/discord"
+	"BinanceTopStrategies/sql"
+	"context"
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// roiPollDB is a strategy due for polling, hot ones belong to TheChosen users or are copied by us
+type roiPollDB struct {
+	StrategyDB
+	Hot      bool `db:"hot"`
+	Failures int  `db:"failures"` // fetches that failed in a row
+}
+
+// nextPoll schedules the next fetch of the strategy, hot strategies come back every cycle, young ones more
+// often than old ones, and series that stopped moving back off until they get concluded
+func nextPoll(s *roiPollDB) time.Time {
+	now := s.RoisFetchedAt
+	if s.Hot {
+		return now.Add(time.Duration(config.TheConfig.RoiPollHotMinutes) * time.Minute)
+	}
+	interval := time.Duration(config.TheConfig.RoiPollOldMinutes) * time.Minute
+	if len(s.rois) == 0 {
+		return now.Add(interval)
+	}
+	latest := time.Unix(s.rois[0].Time, 0)
+	age := latest.Sub(time.Unix(s.rois[len(s.rois)-1].Time, 0))
+	if age < time.Duration(config.TheConfig.RoiPollYoungHours)*time.Hour {
+		interval = time.Duration(config.TheConfig.RoiPollYoungMinutes) * time.Minute
+	}
+	if staleness := now.Sub(latest); staleness > 70*time.Minute {
+		interval = max(interval, staleness/2)
+	}
+	return now.Add(min(interval, time.Duration(config.TheConfig.RoiPollMaxBackoffMinutes)*time.Minute))
+}
+
+// nextPollAfterFailure backs off exponentially from the hot interval, so a strategy that keeps failing
+// doesn't stay at the head of the queue
+func nextPollAfterFailure(failures int) time.Time {
+	maxBackoff := time.Duration(config.TheConfig.RoiPollMaxBackoffMinutes) * time.Minute
+	backoff := time.Duration(config.TheConfig.RoiPollHotMinutes) * time.Minute << min(failures, 16)
+	return clock.Now().Add(min(backoff, maxBackoff))
+}
+
+type roiResult struct {
+	strategy *roiPollDB
+	err      error
+	retries  int
+}
+
+type roiBatch struct {
+	rRows     [][]interface{}
+	fRows     [][]interface{}
+	failed    [][]interface{}
+	concluded int
+}
+
+func (b *roiBatch) add(s *roiPollDB) {
+	concluded := len(s.rois) != 0 && s.RoisFetchedAt.Sub(time.Unix(s.rois[0].Time, 0)) > 130*time.Minute // no new roi in 2 hours
+	if concluded {
+		log.Debugf("Concluded: %d", s.StrategyID)
+		b.concluded++
+	}
+	for _, r := range s.rois {
+		b.rRows = append(b.rRows, []interface{}{s.StrategyID, r.Roi, r.Pnl, time.Unix(r.Time, 0)})
+	}
+	b.fRows = append(b.fRows, []interface{}{s.StrategyID, s.RoisFetchedAt, concluded, nextPoll(s)})
+}
+
+func (b *roiBatch) addFailed(s *roiPollDB) {
+	failures := s.Failures + 1
+	b.failed = append(b.failed, []interface{}{s.StrategyID, nextPollAfterFailure(failures), failures})
+}
+
+func (b *roiBatch) size() int {
+	return len(b.fRows) + len(b.failed)
+}
+
+// commit is a checkpoint, strategies of a committed batch have their rois_fetched_at moved
+// and next_poll_at scheduled, so a crashed run resumes with the rest
+func (b *roiBatch) commit() error {
+	return sql.SimpleTransaction(func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(), `CREATE TEMPORARY TABLE _temp_roi (LIKE bts.roi INCLUDING ALL) ON COMMIT DROP`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CopyFrom(context.Background(), pgx.Identifier{"_temp_roi"}, roiColumns, pgx.CopyFromRows(b.rRows))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(context.Background(), `INSERT INTO bts.roi (strategy_id, roi, pnl, time) SELECT * FROM _temp_roi ON CONFLICT DO NOTHING`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(context.Background(), `CREATE TEMPORARY TABLE _temp_fetched (
+			strategy_id BIGINT, rois_fetched_at TIMESTAMP WITH TIME ZONE, concluded BOOLEAN,
+			next_poll_at TIMESTAMP WITH TIME ZONE) ON COMMIT DROP`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CopyFrom(context.Background(), pgx.Identifier{"_temp_fetched"},
+			[]string{"strategy_id", "rois_fetched_at", "concluded", "next_poll_at"}, pgx.CopyFromRows(b.fRows))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(context.Background(), `UPDATE bts.strategy s
+			SET rois_fetched_at = t.rois_fetched_at,
+			    concluded       = CASE WHEN t.concluded THEN TRUE ELSE s.concluded END
+			FROM _temp_fetched t
+			WHERE s.strategy_id = t.strategy_id`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(context.Background(), `INSERT INTO bts.roi_poll (strategy_id, next_poll_at, failures)
+			SELECT strategy_id, next_poll_at, 0 FROM _temp_fetched
+			ON CONFLICT (strategy_id) DO UPDATE SET next_poll_at = EXCLUDED.next_poll_at, failures = 0`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(context.Background(), `CREATE TEMPORARY TABLE _temp_failed (
+			strategy_id BIGINT, next_poll_at TIMESTAMP WITH TIME ZONE, failures INTEGER) ON COMMIT DROP`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CopyFrom(context.Background(), pgx.Identifier{"_temp_failed"},
+			[]string{"strategy_id", "next_poll_at", "failures"}, pgx.CopyFromRows(b.failed))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(context.Background(), `INSERT INTO bts.roi_poll (strategy_id, next_poll_at, failures)
+			SELECT strategy_id, next_poll_at, failures FROM _temp_failed
+			ON CONFLICT (strategy_id) DO UPDATE SET next_poll_at = EXCLUDED.next_poll_at, failures = EXCLUDED.failures`)
+		return err
+	})
+}
+
+func fetchRois(s *roiPollDB) *roiResult {
+	result := &roiResult{strategy: s}
+	for {
+		rois, err := getStrategyRois(s.StrategyID, s.UserID)
+		if err == nil {
+			s.rois = rois
+			s.RoisFetchedAt = clock.Now()
+			result.err = nil
+			return result
+		}
+		result.err = err
+		if result.retries >= config.TheConfig.RoiMaxRetries {
+			return result
+		}
+		result.retries++
+		time.Sleep(time.Duration(result.retries) * time.Second)
+	}
+}
+
+func PopulateRoi() error {
+	strategies := make([]*roiPollDB, 0)
+	err := sql.GetDB().Scan(&strategies, `SELECT s.*, (c.user_id IS NOT NULL OR g.strategy_id IS NOT NULL) AS hot,
+		       COALESCE(p.failures, 0) AS failures
+		FROM bts.strategy s
+		         LEFT JOIN bts.roi_poll p ON p.strategy_id = s.strategy_id
+		         LEFT JOIN (SELECT DISTINCT user_id FROM bts.TheChosen) c ON c.user_id = s.user_id
+		         LEFT JOIN (SELECT DISTINCT gs.strategy_id
+		                    FROM bts.grid_strategy gs
+		                             JOIN bts.grid g ON g.gid = gs.grid_id
+		                    WHERE g.time > $3::TIMESTAMPTZ - INTERVAL '10 minutes') g ON g.strategy_id = s.strategy_id
+		WHERE (s.concluded = FALSE OR s.concluded IS NULL)
+		  AND s.strategy_type = 2
+		  AND (p.next_poll_at IS NULL OR p.next_poll_at <= $3
+		    OR ((c.user_id IS NOT NULL OR g.strategy_id IS NOT NULL) AND COALESCE(p.failures, 0) = 0
+		        AND s.rois_fetched_at <= $3::TIMESTAMPTZ - MAKE_INTERVAL(mins => $1)))
+		ORDER BY hot DESC, p.next_poll_at NULLS FIRST
+		LIMIT $2`, config.TheConfig.RoiPollHotMinutes, config.TheConfig.RoiMaxPerRun, clock.Now())
+	if err != nil {
+		return err
+	}
+	hot := 0
+	for _, s := range strategies {
+		if s.Hot {
+			hot++
+		}
+	}
+	discord.Infof("Populating roi for %d strategies, %d hot", len(strategies), hot)
+
+	jobs := make(chan *roiPollDB)
+	results := make(chan *roiResult)
+	var aborted atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < max(1, config.TheConfig.RoiConcurrency); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range jobs {
+				results <- fetchRois(s)
+			}
+		}()
+	}
+	go func() {
+		for _, s := range strategies {
+			if aborted.Load() {
+				break
+			}
+			jobs <- s
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	fetchedCount, failedCount, retriedCount, concludedCount, consecutiveErrors := 0, 0, 0, 0, 0
+	batch := &roiBatch{}
+	var commitErr error
+	flush := func() {
+		if batch.size() == 0 {
+			return
+		}
+		err := batch.commit()
+		if err != nil {
+			commitErr = err
+			aborted.Store(true)
+		} else {
+			concludedCount += batch.concluded
+		}
+		batch = &roiBatch{}
+	}
+	for r := range results {
+		retriedCount += r.retries
+		if r.err != nil {
+			failedCount++
+			consecutiveErrors++
+			log.Errorf("Error fetching roi of %d: %v", r.strategy.StrategyID, r.err)
+			batch.addFailed(r.strategy)
+			if consecutiveErrors >= config.TheConfig.RoiMaxConsecutiveErrors && !aborted.Load() {
+				discord.Errorf("Error fetching roi, %d errors in a row, stopping: %v", consecutiveErrors, r.err)
+				aborted.Store(true)
+			}
+			continue
+		}
+		consecutiveErrors = 0
+		fetchedCount++
+		batch.add(r.strategy)
+		if batch.size() >= config.TheConfig.RoiBatchSize {
+			flush()
+		}
+	}
+	flush()
+	discord.Infof("Fetched %d strategies roi, %d failed, %d retries", fetchedCount, failedCount, retriedCount)
+	discord.Infof("Concluded %d strategies", concludedCount)
+	return commitErr
+}
@@ -0,0 +1,185 @@
+package gsp
+
+import (
+	"BinanceTopStrategies/config"
+	"BinanceTopStrategies/discord"
+	"BinanceTopStrategies/sql"
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+)
+
+type priceVersion func(prices []*PriceDB, start, end time.Time) (*PriceMetrics, error)
+
+// priceVersions are the definitions of PriceMetrics, bump config.PriceVersion after adding one
+// and RecomputePrices re-derives every concluded strategy from the stored klines
+var priceVersions = map[int]priceVersion{
+	1: priceMetricsV1,
+}
+
+type priceRecomputeDB struct {
+	StrategyID int64     `db:"strategy_id"`
+	UserID     int64     `db:"user_id"`
+	Symbol     string    `db:"symbol"`
+	StartTime  time.Time `db:"start_time"`
+	EndTime    time.Time `db:"end_time"`
+}
+
+var priceUpdateColumns = []string{
+	"strategy_id",
+	"start_price",
+	"end_price",
+	"start_time",
+	"end_time",
+	"start_price_exact",
+	"end_price_exact",
+	"low_price",
+	"high_price",
+	"start_price_30m_before",
+	"end_price_30m_before",
+	"price_version",
+}
+
+func priceUpdateRow(strategyID int64, m *PriceMetrics) []interface{} {
+	return []interface{}{strategyID, m.StartPrice, m.EndPrice, m.StartTime, m.EndTime,
+		m.StartPriceExact, m.EndPriceExact, m.LowPrice, m.HighPrice,
+		m.StartPrice30MinBefore, m.EndPrice30MinBefore, m.PriceVersion}
+}
+
+// savePrices writes the metrics of many strategies at once through a temp table
+func savePrices(rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	return sql.SimpleTransaction(func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(), `CREATE TEMPORARY TABLE _temp_prices (
+			strategy_id BIGINT, start_price NUMERIC, end_price NUMERIC,
+			start_time TIMESTAMP WITH TIME ZONE, end_time TIMESTAMP WITH TIME ZONE,
+			start_price_exact NUMERIC, end_price_exact NUMERIC, low_price NUMERIC, high_price NUMERIC,
+			start_price_30m_before NUMERIC, end_price_30m_before NUMERIC, price_version INTEGER) ON COMMIT DROP`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CopyFrom(context.Background(), pgx.Identifier{"_temp_prices"}, priceUpdateColumns, pgx.CopyFromRows(rows))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(context.Background(), `UPDATE bts.strategy s
+			SET start_price = t.start_price, end_price = t.end_price,
+			    start_time = t.start_time, end_time = t.end_time,
+			    start_price_exact = t.start_price_exact, end_price_exact = t.end_price_exact,
+			    low_price = t.low_price, high_price = t.high_price,
+			    start_price_30m_before = t.start_price_30m_before, end_price_30m_before = t.end_price_30m_before,
+			    price_version = t.price_version
+			FROM _temp_prices t
+			WHERE s.strategy_id = t.strategy_id`)
+		return err
+	})
+}
+
+// RecomputePrices re-derives the price metrics of up to limit concluded strategies after afterID that another
+// version computed, and drops the WL of their users. Strategies older than the kline backfill window are
+// recomputed out of klines fetched from binance.
+// It returns the last strategy looked at, 0 once there is nothing left after afterID
+func RecomputePrices(afterID int64, limit int) (int64, error) {
+	version := config.TheConfig.PriceVersion
+	strategies := make([]*priceRecomputeDB, 0)
+	err := sql.GetDB().Scan(&strategies, `SELECT strategy_id, user_id, symbol, start_time, end_time
+		FROM bts.strategy
+		WHERE concluded = true
+		  AND high_price IS NOT NULL
+		  AND price_version IS DISTINCT FROM $1
+		  AND strategy_id > $2
+		ORDER BY strategy_id
+		LIMIT $3`, version, afterID, limit)
+	if err != nil || len(strategies) == 0 {
+		return 0, err
+	}
+	rows := make([][]interface{}, 0, len(strategies))
+	users := make(map[int64]bool)
+	failed := 0
+	for _, s := range strategies {
+		metrics, err := GetPrices(s.Symbol, s.StartTime.UnixMilli(), s.EndTime.UnixMilli())
+		if err != nil {
+			log.Debugf("Error recomputing prices %d: %v", s.StrategyID, err)
+			failed++
+			continue
+		}
+		rows = append(rows, priceUpdateRow(s.StrategyID, metrics))
+		users[s.UserID] = true
+	}
+	err = savePrices(rows)
+	if err != nil {
+		return 0, err
+	}
+	err = invalidateWL(users)
+	if err != nil {
+		return 0, err
+	}
+	discord.Infof("Recomputed prices v%d of %d strategies, %d users, %d waiting for klines",
+		version, len(rows), len(users), failed)
+	return strategies[len(strategies)-1].StrategyID, nil
+}
+
+// invalidateWL drops the WL computed out of outdated price metrics, in the cache and in bts.wl.
+// The trading process only sees the cache invalidation with CACHE_BACKEND=redis, with the memory
+// backend it keeps serving its WL until they expire
+func invalidateWL(users map[int64]bool) error {
+	if len(users) == 0 {
+		return nil
+	}
+	precomputedWL := newPrecomputedWLCache() // caches are keyed by name, these drop the entries of the engine
+	userWL := newUserWLCache(precomputedWL)
+	ids := make([]int64, 0, len(users))
+	for u := range users {
+		userWL.Invalidate(strconv.FormatInt(u, 10))
+		ids = append(ids, u)
+	}
+	_, err := sql.GetDB().Exec(context.Background(), `DELETE FROM bts.wl WHERE user_id = ANY($1)`, ids)
+	if err != nil {
+		return fmt.Errorf("error invalidating wl: %w", err)
+	}
+	precomputedWL.Invalidate()
+	return nil
+}
+
+func priceMetricsV1(prices []*PriceDB, start, end time.Time) (*PriceMetrics, error) {
+	if len(prices) > 0 && prices[0].OpenTime.Equal(start) { // listed at start, nothing 30 minutes before
+		prices = append([]*PriceDB{prices[0]}, prices...)
+	}
+	expected := int(end.Sub(start)/klineDuration) + 2
+	if len(prices) != expected {
+		return nil, fmt.Errorf("insufficient data total: %d/%d", len(prices), expected)
+	}
+	if !prices[0].OpenTime.Equal(start.Add(-klineDuration)) && !prices[0].OpenTime.Equal(start) {
+		return nil, fmt.Errorf("open time mismatch: %v, %v", prices[0].OpenTime, start.Add(-klineDuration))
+	}
+	if !prices[len(prices)-1].OpenTime.Equal(end) {
+		return nil, fmt.Errorf("open time mismatch: %v, %v", prices[len(prices)-1].OpenTime, end)
+	}
+	startBefore, startK := prices[0], prices[1]
+	endBefore, endK := prices[len(prices)-2], prices[len(prices)-1]
+	metrics := &PriceMetrics{
+		StartPrice30MinBefore: &startBefore.Open, // start time - 30 minutes
+		StartPriceExact:       &startK.Open,      // start time
+		StartPrice:            &startK.Close,     // start time + 30 minutes
+		EndPrice30MinBefore:   &endBefore.Open,   // end time - 30 minutes
+		EndPriceExact:         &endK.Open,        // end time
+		EndPrice:              &endK.Close,       // end time + 30 minutes
+		StartTime:             &start,
+		EndTime:               &end,
+	}
+	for _, p := range prices {
+		high, low := p.High, p.Low
+		if metrics.HighPrice == nil || high > *metrics.HighPrice {
+			metrics.HighPrice = &high
+		}
+		if metrics.LowPrice == nil || low < *metrics.LowPrice {
+			metrics.LowPrice = &low
+		}
+	}
+	return metrics, nil
+}
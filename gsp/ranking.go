@@ -1,18 +1,15 @@
 package gsp
 
 import (
+	"BinanceTopStrategies/clock"
 	"BinanceTopStrategies/discord"
-	"BinanceTopStrategies/sdk"
 	"BinanceTopStrategies/sql"
-	"BinanceTopStrategies/utils"
 	"context"
+	"errors"
 	"fmt"
-	"github.com/adshao/go-binance/v2/futures"
 	mapset "github.com/deckarep/golang-set/v2"
 	"github.com/jackc/pgx/v5"
 	log "github.com/sirupsen/logrus"
-	"strconv"
-	"strings"
 	"time"
 )
 
@@ -85,6 +82,7 @@ type PriceMetrics struct {
 	HighPrice             *float64   `db:"high_price"`
 	StartPrice30MinBefore *float64   `db:"start_price_30m_before"`
 	EndPrice30MinBefore   *float64   `db:"end_price_30m_before"`
+	PriceVersion          *int       `db:"price_version"`
 }
 
 func floatPtrToStringPtr(f *float64) *string {
@@ -140,105 +138,6 @@ func (db *ChosenStrategyDB) ToStrategy() *Strategy {
 	return s
 }
 
-func GetPrices(symbol string, timeStart int64, timeEnd int64) (*PriceMetrics, error) {
-	if timeStart == timeEnd {
-		timeEnd = timeStart + 3600*1000
-	}
-	metrics := &PriceMetrics{}
-	startRes, err := sdk.FuturesClient.NewKlinesService().Symbol(symbol).Interval("30m").
-		StartTime(timeStart - 30*60*1000).EndTime(timeStart).Limit(4).Do(context.Background())
-	if err != nil {
-		return nil, err
-	}
-	endRes, err := sdk.FuturesClient.NewKlinesService().Symbol(symbol).Interval("30m").
-		StartTime(timeEnd - 30*60*1000).EndTime(timeEnd).Limit(4).Do(context.Background())
-	if err != nil {
-		return nil, err
-	}
-	minMaxRes, err := sdk.FuturesClient.NewKlinesService().Symbol(symbol).Interval("1h").
-		StartTime(timeStart).EndTime(timeEnd).Limit(1500).Do(context.Background())
-	if err != nil {
-		return nil, err
-	}
-	minMaxRes = minMaxRes[:len(minMaxRes)-1]
-	if (len(startRes) != 2 && len(startRes) != 1) || len(endRes) != 2 || len(minMaxRes) < 1 {
-		return nil, fmt.Errorf("insufficient data total: (%d/%d/%d)", len(startRes), len(endRes), len(minMaxRes))
-	}
-	if minMaxRes[0].OpenTime != timeStart || minMaxRes[len(minMaxRes)-1].CloseTime != timeEnd-1 {
-		return nil, fmt.Errorf("time mismatch: %d, %d, %d, %d", minMaxRes[0].OpenTime, timeStart, minMaxRes[len(minMaxRes)-1].CloseTime, timeEnd-1)
-	}
-	if startRes[0].OpenTime != timeStart-30*60*1000 && startRes[0].OpenTime != timeStart {
-		return nil, fmt.Errorf("1: open time mismatch: %d, %d", startRes[0].OpenTime, timeStart-30*60*1000)
-	}
-	if startRes[0].OpenTime != timeStart-30*60*1000 && len(startRes) == 1 {
-		startRes = append(startRes, startRes[0])
-	}
-	if startRes[1].OpenTime != timeStart {
-		return nil, fmt.Errorf("2: open time mismatch: %d, %d", startRes[1].OpenTime, timeStart)
-	}
-	if startRes[1].CloseTime != timeStart+30*60*1000-1 {
-		return nil, fmt.Errorf("3: close time mismatch: %d, %d", startRes[1].CloseTime, timeStart+30*60*1000-1)
-	}
-	if endRes[0].OpenTime != timeEnd-30*60*1000 {
-		return nil, fmt.Errorf("4: open time mismatch: %d, %d", endRes[0].OpenTime, timeEnd-30*60*1000)
-	}
-	if endRes[1].OpenTime != timeEnd {
-		return nil, fmt.Errorf("5: open time mismatch: %d, %d", endRes[1].OpenTime, timeEnd)
-	}
-	if endRes[1].CloseTime != timeEnd+30*60*1000-1 {
-		return nil, fmt.Errorf("6: close time mismatch: %d, %d", endRes[1].CloseTime, timeEnd+30*60*1000-1)
-	}
-	metrics.StartPrice30MinBefore, err = utils.ParseFloatPointer(startRes[0].Open) // start time - 30 minutes
-	if err != nil {
-		return nil, err
-	}
-	metrics.StartPriceExact, err = utils.ParseFloatPointer(startRes[1].Open) // start time
-	if err != nil {
-		return nil, err
-	}
-	metrics.StartPrice, err = utils.ParseFloatPointer(startRes[1].Close) // start time + 30 minutes
-	if err != nil {
-		return nil, err
-	}
-	metrics.EndPrice30MinBefore, err = utils.ParseFloatPointer(endRes[0].Open) // end time - 30 minutes
-	if err != nil {
-		return nil, err
-	}
-	metrics.EndPriceExact, err = utils.ParseFloatPointer(endRes[1].Open) // end time
-	if err != nil {
-		return nil, err
-	}
-	metrics.EndPrice, err = utils.ParseFloatPointer(endRes[1].Close) // end time - 30 minutes
-	if err != nil {
-		return nil, err
-	}
-	startT := time.Unix(timeStart/1000, 0)
-	endT := time.Unix(timeEnd/1000, 0)
-	metrics.StartTime = &startT
-	metrics.EndTime = &endT
-	merged := make([]*futures.Kline, 0)
-	merged = append(merged, startRes...)
-	merged = append(merged, endRes...)
-	merged = append(merged, minMaxRes...)
-	for _, k := range merged {
-		high, err := strconv.ParseFloat(k.High, 64)
-		if err != nil {
-			return nil, err
-		}
-		low, err := strconv.ParseFloat(k.Low, 64)
-		if err != nil {
-			return nil, err
-		}
-		if metrics.HighPrice == nil || high > *metrics.HighPrice {
-			metrics.HighPrice = &high
-		}
-		if metrics.LowPrice == nil || low < *metrics.LowPrice {
-			metrics.LowPrice = &low
-		}
-	}
-	return metrics, nil
-}
-
 func RefreshChosen() error {
 	_, err := sql.GetDB().Exec(context.Background(), `REFRESH MATERIALIZED VIEW bts.TheChosen`)
 	return err
@@ -246,9 +145,28 @@ func RefreshChosen() error {
 
 func RefreshPool() error {
 	_, err := sql.GetDB().Exec(context.Background(), `REFRESH MATERIALIZED VIEW bts.ThePool`)
+	if err != nil {
+		return err
+	}
+	return recordRefresh("ThePool")
+}
+
+func recordRefresh(name string) error {
+	_, err := sql.GetDB().Exec(context.Background(),
+		`INSERT INTO bts.refresh (name, time) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET time = EXCLUDED.time`,
+		name, clock.Now())
 	return err
 }
 
+func LastPoolRefresh() (time.Time, error) {
+	var t time.Time
+	err := sql.GetDB().ScanOne(&t, `SELECT time FROM bts.refresh WHERE name = $1`, "ThePool")
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return t, err
+	}
+	return t, nil
+}
+
 func PopulatePrices() error {
 	strategies := make([]*UserStrategy, 0)
 	err := sql.GetDB().Scan(&strategies, `WITH Pool AS (
@@ -300,125 +218,25 @@ FROM FilteredStrategies f JOIN Pool p ON f.strategy_id = p.strategy_id WHERE f.r
 		return err
 	}
 	discord.Infof("Populating prices for %d strategies", len(strategies))
-	counter := 0
+	missing := 0
+	rows := make([][]interface{}, 0, len(strategies))
 	for _, s := range strategies {
-		log.Debugf("Fetching prices for %s: %d, %d", s.Symbol, s.StrategyID, s.UserID)
+		log.Debugf("Computing prices for %s: %d, %d", s.Symbol, s.StrategyID, s.UserID)
 		metrics, err := GetPrices(s.Symbol,
 			s.StartTime.UnixMilli(), s.EndTime.UnixMilli())
 		if err != nil {
-			if strings.Contains(err.Error(), "Too many requests") {
-				discord.Errorf("Too many requests: %v", err)
-				break
-			} else {
-				discord.Errorf("Error fetching prices %d: %v", s.StrategyID, err)
-				continue
-			}
-		}
-		_, err = sql.GetDB().Exec(context.Background(), `UPDATE bts.strategy SET 
-                        start_price = $1, end_price = $2,
-                        start_time = $3, end_time = $4,
-                        start_price_exact = $5, end_price_exact = $6,
-                        low_price = $7, high_price = $8,
-                        start_price_30m_before = $9, end_price_30m_before = $10
-                        WHERE strategy_id = $11`,
-			metrics.StartPrice, metrics.EndPrice,
-			metrics.StartTime, metrics.EndTime,
-			metrics.StartPriceExact, metrics.EndPriceExact,
-			metrics.LowPrice, metrics.HighPrice,
-			metrics.StartPrice30MinBefore, metrics.EndPrice30MinBefore,
-			s.StrategyID)
-		if err != nil {
-			break
-		}
-		counter++
-		if counter > 110 {
-			break
+			log.Debugf("Error computing prices %d: %v", s.StrategyID, err)
+			missing++
+			continue
 		}
+		rows = append(rows, priceUpdateRow(s.StrategyID, metrics))
 	}
-	discord.Infof("Populated prices for %d strategies", counter)
-	return err
-}
-
-func PopulateRoi() error {
-	strategies := make([]*StrategyDB, 0)
-	err := sql.GetDB().Scan(&strategies, `SELECT
-    * FROM bts.ToPopulate;`)
+	err = savePrices(rows)
 	if err != nil {
 		return err
 	}
-	discord.Infof("Populating roi for %d strategies", len(strategies))
-	if len(strategies) > 0 {
-		discord.Infof("Earliest strategy: %s", strategies[0].TimeDiscovered)
-	}
-	concludedCount := 0
-	fetchedCount := 0
-	populatedStrategies := make(map[int64]*StrategyDB)
-	rRows := make([][]interface{}, 0)
-	for _, s := range strategies {
-		log.Debugf("Fetching Roi: %d", s.StrategyID)
-		rois, err := getStrategyRois(s.StrategyID, s.UserID)
-		if err != nil {
-			discord.Errorf("Error fetching roi: %v", err)
-			break
-		}
-		s.rois = rois
-		s.RoisFetchedAt = time.Now()
-		populatedStrategies[s.StrategyID] = s
-		fetchedCount++
-		for _, r := range s.rois {
-			rRows = append(rRows, []interface{}{s.StrategyID,
-				r.Roi,
-				r.Pnl,
-				time.Unix(r.Time, 0)})
-		}
-		if fetchedCount > 5000 {
-			break
-		}
-	}
-	discord.Infof("Fetched %d strategies roi", fetchedCount)
-	err = sql.SimpleTransaction(func(tx pgx.Tx) error {
-		_, err := tx.Exec(context.Background(), `CREATE TEMPORARY TABLE _temp_roi (LIKE bts.roi INCLUDING ALL) ON COMMIT DROP`)
-		if err != nil {
-			return err
-		}
-		rows, err := tx.CopyFrom(context.Background(), pgx.Identifier{"_temp_roi"},
-			roiColumns, pgx.CopyFromRows(rRows))
-		if err != nil {
-			return err
-		}
-		discord.Infof("Inserted %d rois", rows)
-		_, err = tx.Exec(context.Background(), `INSERT INTO bts.roi (strategy_id, roi, pnl, time) SELECT * FROM _temp_roi ON CONFLICT DO NOTHING`)
-		if err != nil {
-			return err
-		}
-		for _, s := range populatedStrategies {
-			_, err = tx.Exec(context.Background(),
-				`UPDATE bts.strategy SET rois_fetched_at = $1 WHERE strategy_id = $2`,
-				s.RoisFetchedAt,
-				s.StrategyID,
-			)
-			if err != nil {
-				return err
-			}
-			rois := s.rois
-			if len(rois) != 0 && s.RoisFetchedAt.Sub(time.Unix(rois[0].Time, 0)) > 130*time.Minute {
-				// concluded: if no new roi fetched in 2 hours
-				_, err := tx.Exec(context.Background(),
-					`UPDATE bts.strategy SET concluded = $1 WHERE strategy_id = $2`,
-					true,
-					s.StrategyID,
-				)
-				if err != nil {
-					return err
-				}
-				log.Debugf("Concluded: %d", s.StrategyID)
-				concludedCount++
-			}
-		}
-		return nil
-	})
-	discord.Infof("Concluded %d strategies", concludedCount)
-	return err
+	discord.Infof("Populated prices for %d strategies, %d waiting for klines", len(rows), missing)
+	return nil
 }
 
 var roiColumns = []string{
@@ -476,7 +294,7 @@ func addToRankingStore(ss Strategies) error {
 	users := mapset.NewSet[int]()
 	for _, s := range ss {
 		users.Add(s.UserID)
-		s.TimeDiscovered = time.Now()
+		s.TimeDiscovered = clock.Now()
 		sRows = append(sRows, []interface{}{
 			s.Symbol,
 			s.CopyCount,
@@ -2,14 +2,15 @@ package gsp
 
 import (
 	"BinanceTopStrategies/cache"
+	"BinanceTopStrategies/clock"
+	"BinanceTopStrategies/config"
 	"BinanceTopStrategies/discord"
 	"BinanceTopStrategies/request"
 	"BinanceTopStrategies/sql"
-	"BinanceTopStrategies/utils"
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/jackc/pgx/v5"
-	log "github.com/sirupsen/logrus"
 	"math"
 	"slices"
 	"sort"
@@ -18,33 +19,38 @@ import (
 	"time"
 )
 
-var RoisCache = cache.CreateMapCache[StrategyRoi](
-	func(key string) (StrategyRoi, error) {
-		split := strings.Split(key, "-")
-		SID, _ := strconv.Atoi(split[0])
-		UserId, _ := strconv.Atoi(split[1])
-		roi, err := getStrategyRois(int64(SID), int64(UserId))
-		if err != nil {
-			return nil, err
-		}
-		return roi, nil
-	},
-	func(rois StrategyRoi) bool {
-		if len(rois) == 0 {
-			return true
-		}
-		latestTime := time.Unix(rois[0].Time, 0)
-		if time.Now().Sub(latestTime) > 60*time.Minute {
-			return true
-		}
-		return false
-	},
-)
+func newRoisCache() *cache.MapCache[StrategyRoi] {
+	return &cache.MapCache[StrategyRoi]{
+		Name:      "rois",
+		Retention: 2 * time.Hour,
+		FetchMethod: func(key string) (StrategyRoi, error) {
+			split := strings.Split(key, "-")
+			SID, _ := strconv.Atoi(split[0])
+			UserId, _ := strconv.Atoi(split[1])
+			roi, err := getStrategyRois(int64(SID), int64(UserId))
+			if err != nil {
+				return nil, err
+			}
+			return roi, nil
+		},
+		HasExpired: func(rois StrategyRoi) bool {
+			if len(rois) == 0 {
+				return true
+			}
+			latestTime := time.Unix(rois[0].Time, 0)
+			if clock.Since(latestTime) > 60*time.Minute {
+				return true
+			}
+			return false
+		},
+	}
+}
 
 type UserWL struct {
-	UpdatedAt   time.Time `json:"updatedAt"`
-	DirectionWL map[int]*WL
-	UserId      int `json:"userId"`
+	UpdatedAt   time.Time           `json:"updatedAt"`
+	DirectionWL map[int]*WL         // the version selected by WL_VERSION
+	Versions    map[int]map[int]*WL // every computed version, including shadows
+	UserId      int                 `json:"userId"`
 }
 
 type WL struct {
@@ -54,20 +60,57 @@ type WL struct {
 	WinRatio          float64
 	ShortRunning      float64
 	ShortRunningRatio float64
+	Score             float64 // confidence adjusted win ratio
+	ScoreMethod       string  // what Score was computed with, see ScoreMethod
 	EarliestTime      time.Time
 	Id                string
 }
 
-const (
-	WlVersion = 1
-)
+// wlJSON carries the ratios that are NaN when there is nothing to divide by, json has no NaN
+type wlJSON struct {
+	plainWL
+	NaN []string `json:"nan,omitempty"`
+}
+
+type plainWL WL
+
+func (w WL) MarshalJSON() ([]byte, error) {
+	j := wlJSON{plainWL: plainWL(w)}
+	for name, f := range map[string]*float64{"WinRatio": &j.WinRatio, "ShortRunningRatio": &j.ShortRunningRatio} {
+		if math.IsNaN(*f) {
+			*f = 0
+			j.NaN = append(j.NaN, name)
+		}
+	}
+	return json.Marshal(j)
+}
+
+func (w *WL) UnmarshalJSON(data []byte) error {
+	j := wlJSON{}
+	err := json.Unmarshal(data, &j)
+	if err != nil {
+		return err
+	}
+	for _, name := range j.NaN {
+		switch name {
+		case "WinRatio":
+			j.WinRatio = math.NaN()
+		case "ShortRunningRatio":
+			j.ShortRunningRatio = math.NaN()
+		}
+	}
+	*w = WL(j.plainWL)
+	return nil
+}
 
 func (wl UserWL) insert() {
 	err := sql.SimpleTransaction(func(tx pgx.Tx) error {
-		for _, w := range wl.DirectionWL {
-			err := w.insert(wl.UserId, wl.UpdatedAt, tx)
-			if err != nil {
-				return err
+		for version, directionWL := range wl.Versions {
+			for _, w := range directionWL {
+				err := w.insert(wl.UserId, wl.UpdatedAt, version, tx)
+				if err != nil {
+					return err
+				}
 			}
 		}
 		return nil
@@ -77,13 +120,13 @@ func (wl UserWL) insert() {
 	}
 }
 
-func (wl WL) insert(userId int, updatedAt time.Time, tx pgx.Tx) error {
+func (wl WL) insert(userId int, updatedAt time.Time, version int, tx pgx.Tx) error {
 	if wl.Total == 0 {
 		return nil
 	}
 	_, err := tx.Exec(context.Background(),
-		`INSERT INTO bts.wl (user_id, direction, total, total_wl, win, win_ratio, short_running, short_running_ratio, earliest, time_updated, version) 
-    			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) ON CONFLICT (user_id, direction) DO UPDATE
+		`INSERT INTO bts.wl (user_id, direction, total, total_wl, win, win_ratio, short_running, short_running_ratio, earliest, time_updated, version, score, score_method) 
+    			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) ON CONFLICT (user_id, direction, version) DO UPDATE
     			SET total = EXCLUDED.total,
     			    total_wl = EXCLUDED.total_wl,
     			    win = EXCLUDED.win,
@@ -92,8 +135,10 @@ func (wl WL) insert(userId int, updatedAt time.Time, tx pgx.Tx) error {
     			    short_running_ratio = EXCLUDED.short_running_ratio,
     			    earliest = EXCLUDED.earliest,
     			    time_updated = EXCLUDED.time_updated,
-    			    version = EXCLUDED.version;`,
-		userId, wl.Id, wl.Total, wl.TotalWL, wl.Win, wl.WinRatio, wl.ShortRunning, wl.ShortRunningRatio, wl.EarliestTime, updatedAt, WlVersion)
+    			    score = EXCLUDED.score,
+    			    score_method = EXCLUDED.score_method;`,
+		userId, wl.Id, wl.Total, wl.TotalWL, wl.Win, wl.WinRatio, wl.ShortRunning, wl.ShortRunningRatio, wl.EarliestTime, updatedAt, version, wl.Score,
+		wl.ScoreMethod)
 	if err != nil {
 		discord.Errorf("Error inserting WL: %v", err)
 	}
@@ -110,19 +155,76 @@ func (wl WL) String() string {
 	if wl.Total == 0 {
 		return ""
 	} else {
-		return fmt.Sprintf("%s: [%.1f%% (%.1f/%.1f)|Short: %.1f%% (%.1f/%.1f)|%v]",
-			wl.Id, wl.WinRatio*100, wl.Win, wl.TotalWL,
+		return fmt.Sprintf("%s: [%.1f%% (%.1f/%.1f), Score: %.1f%%|Short: %.1f%% (%.1f/%.1f)|%v]",
+			wl.Id, wl.WinRatio*100, wl.Win, wl.TotalWL, wl.Score*100,
 			wl.ShortRunningRatio*100, wl.ShortRunning, wl.Total, wl.EarliestTime)
 	}
 }
 
-var UserWLCache = cache.CreateMapCache[UserWL](
-	func(key string) (UserWL, error) {
-		user, _ := strconv.Atoi(key)
-		strategies := make([]*UserStrategy, 0)
-		err := sql.GetDB().Scan(&strategies,
-			`WITH Pool AS (
-    SELECT * FROM bts.strategy WHERE user_id = $1 AND concluded=true AND high_price IS NOT NULL AND strategy_type = 2
+// newUserWLCache serves the previous WL for up to an hour past expiry while the heavy query runs in background,
+// reading the WL precomputed by ComputeAllWL first
+func newUserWLCache(precomputedWL *cache.Cache[map[int]UserWL]) *cache.MapCache[UserWL] {
+	return &cache.MapCache[UserWL]{
+		Name:     "wl",
+		TTL:      time.Hour,
+		MaxStale: 2 * time.Hour,
+		HasExpired: func(wl UserWL) bool {
+			total, ok := wl.DirectionWL[TOTAL]
+			return ok && total.ScoreMethod != ScoreMethod()
+		},
+		FetchMethod: func(key string) (UserWL, error) {
+			user, _ := strconv.Atoi(key)
+			precomputed, err := precomputedWL.Get()
+			if err != nil {
+				discord.Errorf("Error reading precomputed WL: %v", err)
+			} else if wl, ok := precomputed[user]; ok {
+				return wl, nil
+			}
+			strategies, err := getUserStrategiesForWL(user)
+			if err != nil {
+				return UserWL{}, err
+			}
+			wl, err := computeUserWL(user, strategies)
+			if err != nil {
+				return UserWL{}, err
+			}
+			wl.insert()
+			return wl, nil
+		},
+	}
+}
+
+// wlVersions are the scorer versions computed for every user, the active one first
+func wlVersions() []int {
+	versions := []int{config.TheConfig.WlVersion}
+	for _, v := range config.TheConfig.WlShadowVersions {
+		if !slices.Contains(versions, v) {
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
+
+func computeUserWL(user int, strategies []*UserStrategy) (UserWL, error) {
+	wl := UserWL{
+		UpdatedAt: clock.Now(),
+		Versions:  make(map[int]map[int]*WL),
+		UserId:    user}
+	for _, version := range wlVersions() {
+		scorer, err := GetScorer(version)
+		if err != nil {
+			return UserWL{}, err
+		}
+		wl.Versions[version] = scorer.Score(strategies)
+	}
+	wl.DirectionWL = wl.Versions[config.TheConfig.WlVersion]
+	return wl, nil
+}
+
+// userStrategiesForWLQuery selects the concluded strategies WL is scored from, for the users matching where
+func userStrategiesForWLQuery(where string) string {
+	return fmt.Sprintf(`WITH Pool AS (
+    SELECT * FROM bts.strategy WHERE %s AND concluded=true AND high_price IS NOT NULL AND strategy_type = 2
 ), LatestRoi AS (
     SELECT
         r.strategy_id,
@@ -154,130 +256,14 @@ var UserWLCache = cache.CreateMapCache[UserWL](
           p.leverage, p.trailing_down, p.trailing_up, p.trailing_type, p.latest_matched_count, p.matched_count, p.min_investment,
           p.concluded
 FROM FilteredStrategies f JOIN Pool p ON f.strategy_id = p.strategy_id
-WHERE f.original_input > 349;`, user)
-		if err != nil {
-			return UserWL{}, err
-		}
-		directionWL := map[int]*WL{
-			TOTAL:   {Id: "TOTAL"},
-			LONG:    {Id: "LONG"},
-			SHORT:   {Id: "SHORT"},
-			NEUTRAL: {Id: "NEUTRAL"},
-		}
-		wl := UserWL{
-			UpdatedAt:   time.Now(),
-			DirectionWL: directionWL,
-			UserId:      user}
-		for _, s := range strategies {
-			start := *s.StartPrice
-			end := *s.EndPrice
-			high := *s.HighPrice
-			low := *s.LowPrice
-			s.RunningTime = int(s.EndTime.Sub(*s.StartTime).Seconds())
-			if err != nil {
-				return UserWL{}, err
-			}
-			priceDiffPct := math.Abs((end - start) / start)
-			smlChange := priceDiffPct < 0.006
-			shortRunning := s.RunningTime <= 3600*2
-			w := directionWL[s.Direction]
-			w.Total++
-			if shortRunning {
-				w.ShortRunning++
-			}
-			if !(shortRunning && smlChange) {
-				w.TotalWL++
-			} else {
-				continue
-			}
-			if w.EarliestTime.IsZero() || s.StartTime.Before(w.EarliestTime) {
-				w.EarliestTime = *s.StartTime
-			}
-			switch s.Direction {
-			case LONG:
-				if end > start && s.ROI > 0 {
-					modifier := 1.0
-					if low <= s.LowerLimit {
-						modifier *= 0.1
-					}
-					lowDiff := (start - low) / start
-					if lowDiff > 0.1 {
-						modifier *= 0.1
-					}
-					if smlChange {
-						modifier *= 0.5
-					}
-					w.Win += modifier * 1
-				} else if !smlChange {
-					w.Win -= 1
-				}
-			case SHORT:
-				if end < start && s.ROI > 0 {
-					modifier := 1.0
-					if high >= s.UpperLimit {
-						modifier *= 0.1
-					}
-					highDiff := (high - start) / start
-					if highDiff > 0.1 {
-						modifier *= 0.1
-					}
-					if smlChange {
-						modifier *= 0.5
-					}
-					w.Win += modifier * 1
-				} else if !smlChange {
-					w.Win -= 1
-				}
-			case NEUTRAL:
-				threshold := 0.065
-				lossThreshold := 0.15
-				mid := (s.LowerLimit + s.UpperLimit) / 2
-				if end < s.UpperLimit && end > s.LowerLimit && s.ROI > 0 {
-					modifier := 1.0
-					if low <= s.LowerLimit || high >= s.UpperLimit {
-						modifier *= 0
-					}
-					if utils.InRange(end, start, threshold) {
-						modifier *= 1
-					} else if utils.InRange(end, mid, threshold) {
-						modifier *= 0.8
-					} else if utils.InRange(end, start, lossThreshold) {
-						modifier *= 0.4
-					} else {
-						modifier *= 0.1
-					}
-					w.Win += modifier
-				} else {
-					w.Win -= 12
-				}
-			}
-			log.Debugf("Symbol: %s, Direction: %d, Start: %.5f, End: %.5f, %v (%.5f, %.5f)",
-				s.Symbol, s.Direction, start, end, time.Duration(s.RunningTime)*time.Second, s.LowerLimit, s.UpperLimit)
-		}
-		directionWL[LONG].WinRatio = directionWL[LONG].Win / directionWL[LONG].TotalWL
-		directionWL[LONG].ShortRunningRatio = directionWL[LONG].ShortRunning / directionWL[LONG].Total
-		directionWL[SHORT].WinRatio = directionWL[SHORT].Win / directionWL[SHORT].TotalWL
-		directionWL[SHORT].ShortRunningRatio = directionWL[SHORT].ShortRunning / directionWL[SHORT].Total
-		directionWL[NEUTRAL].WinRatio = directionWL[NEUTRAL].Win / directionWL[NEUTRAL].TotalWL
-		directionWL[NEUTRAL].ShortRunningRatio = directionWL[NEUTRAL].ShortRunning / directionWL[NEUTRAL].Total
-		directionWL[TOTAL] = &WL{
-			Id:      "TOTAL",
-			TotalWL: directionWL[LONG].TotalWL + directionWL[SHORT].TotalWL + directionWL[NEUTRAL].TotalWL,
-			Total:   directionWL[LONG].Total + directionWL[SHORT].Total + directionWL[NEUTRAL].Total,
-			Win:     directionWL[LONG].Win + directionWL[SHORT].Win + directionWL[NEUTRAL].Win,
-			WinRatio: (directionWL[LONG].Win + directionWL[SHORT].Win + directionWL[NEUTRAL].Win) /
-				(directionWL[LONG].TotalWL + directionWL[SHORT].TotalWL + directionWL[NEUTRAL].TotalWL),
-			ShortRunning: directionWL[LONG].ShortRunning + directionWL[SHORT].ShortRunning + directionWL[NEUTRAL].ShortRunning,
-			ShortRunningRatio: (directionWL[LONG].ShortRunning + directionWL[SHORT].ShortRunning + directionWL[NEUTRAL].ShortRunning) /
-				(directionWL[LONG].Total + directionWL[SHORT].Total + directionWL[NEUTRAL].Total),
-			EarliestTime: utils.MinTime(directionWL[LONG].EarliestTime, directionWL[SHORT].EarliestTime, directionWL[NEUTRAL].EarliestTime),
-		}
-		wl.insert()
-		return wl, nil
-	},
-	func(wl UserWL) bool {
-		return time.Now().Sub(wl.UpdatedAt) > 1*time.Hour
-	})
+WHERE f.original_input IS NOT NULL;`, where)
+}
+
+func getUserStrategiesForWL(user int) ([]*UserStrategy, error) {
+	strategies := make([]*UserStrategy, 0)
+	err := sql.GetDB().Scan(&strategies, userStrategiesForWLQuery("user_id = $1"), user)
+	return strategies, err
+}
 
 type StrategyRoi []*Roi
 
@@ -307,7 +293,7 @@ func getStrategyRois(strategyID int64, rootUserId int64) (StrategyRoi, error) {
 		StreamerStrategyType: "UM_GRID",
 	}
 	roi, _, err := request.Request(
-		"https://www.binance.com/bapi/futures/v1/public/future/common/strategy/landing-page/queryRoiChart",
+		request.Url("/bapi/futures/v1/public/future/common/strategy/landing-page/queryRoiChart"),
 		query, &StrategyRoiResponse{})
 	if err != nil {
 		return nil, err
@@ -0,0 +1,52 @@
+package gsp
+
+import (
+	"BinanceTopStrategies/cache"
+	"BinanceTopStrategies/config"
+	"github.com/alicebob/miniredis/v2"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestUserWLCacheNaN(t *testing.T) {
+	config.Init()
+	mr := miniredis.RunT(t)
+	redis, err := cache.NewRedis(mr.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, backend := range map[string]cache.Backend{"memory": cache.NewMemory(10, nil), "redis": redis} {
+		t.Run(name, func(t *testing.T) {
+			fetches := 0
+			c := &cache.MapCache[*UserWL]{
+				Name:    "user_wl_nan",
+				TTL:     time.Hour,
+				Backend: backend,
+				FetchMethod: func(string) (*UserWL, error) {
+					fetches++
+					wl := newDirectionWL()
+					wl[TOTAL].WinRatio = math.NaN()
+					wl[TOTAL].ShortRunningRatio = math.NaN()
+					wl[LONG].WinRatio = 0.5
+					return &UserWL{DirectionWL: wl, UserId: 1}, nil
+				},
+			}
+			_, _ = c.Get("1")
+			userWL, err := c.Get("1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fetches != 1 {
+				t.Fatalf("fetched %d times, want 1", fetches)
+			}
+			total := userWL.DirectionWL[TOTAL]
+			if !math.IsNaN(total.WinRatio) || !math.IsNaN(total.ShortRunningRatio) {
+				t.Fatalf("got %v %v, want NaN ratios", total.WinRatio, total.ShortRunningRatio)
+			}
+			if userWL.DirectionWL[LONG].WinRatio != 0.5 {
+				t.Fatalf("got %v, want 0.5", userWL.DirectionWL[LONG].WinRatio)
+			}
+		})
+	}
+}
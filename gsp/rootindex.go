@@ -0,0 +1,94 @@
+package gsp
+
+import (
+	"BinanceTopStrategies/utils"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// rootWindow is how far the runtime reported by a grid or the pool may be off from the running strategy
+const rootWindow = 9 * time.Hour
+
+type rootKey struct {
+	Base      string // symbol without its quote, the USDT and USDC variants share an entry
+	Direction int
+	Bucket    int // runtime in rootWindow units
+}
+
+type rootEntry struct {
+	once   sync.Once
+	bySID  map[int]*Strategy
+	capped bool
+	err    error
+}
+
+// rootIndex answers which strategies are running out of one top strategies query per symbol, direction and runtime bucket,
+// it lives for one tick
+type rootIndex struct {
+	mutex   sync.Mutex
+	entries map[rootKey]*rootEntry
+}
+
+func newRootIndex() *rootIndex {
+	return &rootIndex{entries: make(map[rootKey]*rootEntry)}
+}
+
+func (idx *rootIndex) entry(key rootKey) *rootEntry {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+	e, ok := idx.entries[key]
+	if !ok {
+		e = &rootEntry{}
+		idx.entries[key] = e
+	}
+	return e
+}
+
+// load covers the runtimes of the whole bucket widened by rootWindow on both sides,
+// so any runtime in the bucket gets at least the ±rootWindow of a single lookup
+func (e *rootEntry) load(key rootKey) {
+	e.once.Do(func() {
+		runtimeMin := max(0, time.Duration(key.Bucket-1)*rootWindow)
+		runtimeMax := time.Duration(key.Bucket+2) * rootWindow
+		queries := make([]StrategyQuery, 0, 2)
+		for _, quote := range []string{"USDT", "USDC"} {
+			queries = append(queries, StrategyQuery{Type: FUTURE, Sort: SortByPnl,
+				RuntimeMin: runtimeMin, RuntimeMax: runtimeMax,
+				Symbol: key.Base + quote, Direction: utils.IntPointer(key.Direction),
+				Count: 2000, Source: "root"})
+		}
+		strategies, coverage, err := crawlStrategies(queries...)
+		if err != nil {
+			e.err = err
+			return
+		}
+		e.bySID = make(map[int]*Strategy, len(strategies))
+		for _, s := range strategies {
+			e.bySID[s.SID] = s
+		}
+		for _, c := range coverage {
+			e.capped = e.capped || c.Capped > 0
+		}
+	})
+}
+
+// DiscoverRootStrategy finds the running strategy sid from the index of this tick,
+// a miss in a capped query is confirmed with the exact query
+func (e *Engine) DiscoverRootStrategy(sid int, symbol string, direction int, roughRuntime time.Duration) (*Strategy, error) {
+	key := rootKey{Base: symbol[:len(symbol)-4], Direction: direction, Bucket: int(max(0, roughRuntime) / rootWindow)}
+	entry := e.roots.entry(key)
+	entry.load(key)
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	if s, ok := entry.bySID[sid]; ok {
+		found := *s // callers fill in rois and metrics on their own copy
+		return &found, nil
+	}
+	if entry.capped {
+		log.Debugf("Strategy %d not in capped root index %v, querying it", sid, key)
+		return DiscoverRootStrategy(sid, symbol, direction, roughRuntime)
+	}
+	return nil, nil
+}
@@ -0,0 +1,236 @@
+package gsp
+
+import (
+	"BinanceTopStrategies/clock"
+	"BinanceTopStrategies/config"
+	"BinanceTopStrategies/utils"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"sort"
+	"time"
+)
+
+// Scorer turns a user's concluded strategies into per direction WL, every version is stored
+// side by side in bts.wl so a new idea can be compared against live outcomes before it drives decisions
+type Scorer interface {
+	Version() int
+	Score(strategies []*UserStrategy) map[int]*WL
+}
+
+var scorers = make(map[int]Scorer)
+
+func RegisterScorer(scorer Scorer) {
+	if _, ok := scorers[scorer.Version()]; ok {
+		panic(fmt.Sprintf("scorer version %d registered twice", scorer.Version()))
+	}
+	scorers[scorer.Version()] = scorer
+}
+
+func GetScorer(version int) (Scorer, error) {
+	scorer, ok := scorers[version]
+	if !ok {
+		return nil, fmt.Errorf("scorer version %d not registered", version)
+	}
+	return scorer, nil
+}
+
+func ScorerVersions() []int {
+	versions := make([]int, 0)
+	for v := range scorers {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+func init() {
+	RegisterScorer(scorerV1{})
+	RegisterScorer(scorerV2{})
+}
+
+func newDirectionWL() map[int]*WL {
+	return map[int]*WL{
+		TOTAL:   {Id: "TOTAL"},
+		LONG:    {Id: "LONG"},
+		SHORT:   {Id: "SHORT"},
+		NEUTRAL: {Id: "NEUTRAL"},
+	}
+}
+
+// finalizeWL derives the ratios of every direction and aggregates them into TOTAL
+func finalizeWL(directionWL map[int]*WL) {
+	for _, d := range []int{LONG, SHORT, NEUTRAL} {
+		directionWL[d].WinRatio = directionWL[d].Win / directionWL[d].TotalWL
+		directionWL[d].ShortRunningRatio = directionWL[d].ShortRunning / directionWL[d].Total
+		directionWL[d].Score = Confidence(directionWL[d].Win, directionWL[d].TotalWL)
+		directionWL[d].ScoreMethod = ScoreMethod()
+	}
+	directionWL[TOTAL] = &WL{
+		Id:      "TOTAL",
+		TotalWL: directionWL[LONG].TotalWL + directionWL[SHORT].TotalWL + directionWL[NEUTRAL].TotalWL,
+		Total:   directionWL[LONG].Total + directionWL[SHORT].Total + directionWL[NEUTRAL].Total,
+		Win:     directionWL[LONG].Win + directionWL[SHORT].Win + directionWL[NEUTRAL].Win,
+		WinRatio: (directionWL[LONG].Win + directionWL[SHORT].Win + directionWL[NEUTRAL].Win) /
+			(directionWL[LONG].TotalWL + directionWL[SHORT].TotalWL + directionWL[NEUTRAL].TotalWL),
+		ShortRunning: directionWL[LONG].ShortRunning + directionWL[SHORT].ShortRunning + directionWL[NEUTRAL].ShortRunning,
+		ShortRunningRatio: (directionWL[LONG].ShortRunning + directionWL[SHORT].ShortRunning + directionWL[NEUTRAL].ShortRunning) /
+			(directionWL[LONG].Total + directionWL[SHORT].Total + directionWL[NEUTRAL].Total),
+		EarliestTime: utils.MinTime(directionWL[LONG].EarliestTime, directionWL[SHORT].EarliestTime, directionWL[NEUTRAL].EarliestTime),
+	}
+	directionWL[TOTAL].Score = Confidence(directionWL[TOTAL].Win, directionWL[TOTAL].TotalWL)
+	directionWL[TOTAL].ScoreMethod = ScoreMethod()
+}
+
+// ScoreMethod names the confidence method and its parameters, scores are only comparable under the same method
+func ScoreMethod() string {
+	switch config.TheConfig.WlConfidence {
+	case "beta":
+		return fmt.Sprintf("beta(alpha=%g,beta=%g)", config.TheConfig.WlPriorAlpha, config.TheConfig.WlPriorBeta)
+	default:
+		return fmt.Sprintf("wilson(z=%g)", config.TheConfig.WlWilsonZ)
+	}
+}
+
+// Confidence scores win out of total so that a long track record beats a short perfect one,
+// wins are clamped into [0, total] as penalties can push them below zero
+func Confidence(win, total float64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	win = math.Max(0, math.Min(win, total))
+	switch config.TheConfig.WlConfidence {
+	case "beta":
+		alpha := config.TheConfig.WlPriorAlpha
+		beta := config.TheConfig.WlPriorBeta
+		return (win + alpha) / (total + alpha + beta)
+	default:
+		z := config.TheConfig.WlWilsonZ
+		p := win / total
+		z2 := z * z
+		return (p + z2/(2*total) - z*math.Sqrt(p*(1-p)/total+z2/(4*total*total))) / (1 + z2/total)
+	}
+}
+
+type scorerV1 struct{}
+
+func (scorerV1) Version() int {
+	return 1
+}
+
+func (scorerV1) Score(strategies []*UserStrategy) map[int]*WL {
+	return scoreRules(strategies, func(*UserStrategy) float64 {
+		return 1
+	})
+}
+
+// scorerV2 uses the same rules as v1, but recent strategies count more than older ones
+type scorerV2 struct{}
+
+func (scorerV2) Version() int {
+	return 2
+}
+
+func (scorerV2) Score(strategies []*UserStrategy) map[int]*WL {
+	halfLife := time.Duration(config.TheConfig.WlDecayHalfLifeDays * float64(24*time.Hour))
+	now := clock.Now()
+	return scoreRules(strategies, func(s *UserStrategy) float64 {
+		if halfLife <= 0 {
+			return 1
+		}
+		return math.Pow(0.5, float64(now.Sub(*s.EndTime))/float64(halfLife))
+	})
+}
+
+func scoreRules(strategies []*UserStrategy, weightOf func(s *UserStrategy) float64) map[int]*WL {
+	directionWL := newDirectionWL()
+	for _, s := range strategies {
+		if s.UserInput <= 349 {
+			continue
+		}
+		weight := weightOf(s)
+		start := *s.StartPrice
+		end := *s.EndPrice
+		high := *s.HighPrice
+		low := *s.LowPrice
+		s.RunningTime = int(s.EndTime.Sub(*s.StartTime).Seconds())
+		priceDiffPct := math.Abs((end - start) / start)
+		smlChange := priceDiffPct < 0.006
+		shortRunning := s.RunningTime <= 3600*2
+		w := directionWL[s.Direction]
+		w.Total += weight
+		if shortRunning {
+			w.ShortRunning += weight
+		}
+		if !(shortRunning && smlChange) {
+			w.TotalWL += weight
+		} else {
+			continue
+		}
+		if w.EarliestTime.IsZero() || s.StartTime.Before(w.EarliestTime) {
+			w.EarliestTime = *s.StartTime
+		}
+		switch s.Direction {
+		case LONG:
+			if end > start && s.ROI > 0 {
+				modifier := 1.0
+				if low <= s.LowerLimit {
+					modifier *= 0.1
+				}
+				lowDiff := (start - low) / start
+				if lowDiff > 0.1 {
+					modifier *= 0.1
+				}
+				if smlChange {
+					modifier *= 0.5
+				}
+				w.Win += modifier * weight
+			} else if !smlChange {
+				w.Win -= weight
+			}
+		case SHORT:
+			if end < start && s.ROI > 0 {
+				modifier := 1.0
+				if high >= s.UpperLimit {
+					modifier *= 0.1
+				}
+				highDiff := (high - start) / start
+				if highDiff > 0.1 {
+					modifier *= 0.1
+				}
+				if smlChange {
+					modifier *= 0.5
+				}
+				w.Win += modifier * weight
+			} else if !smlChange {
+				w.Win -= weight
+			}
+		case NEUTRAL:
+			threshold := 0.065
+			lossThreshold := 0.15
+			mid := (s.LowerLimit + s.UpperLimit) / 2
+			if end < s.UpperLimit && end > s.LowerLimit && s.ROI > 0 {
+				modifier := 1.0
+				if low <= s.LowerLimit || high >= s.UpperLimit {
+					modifier *= 0
+				}
+				if utils.InRange(end, start, threshold) {
+					modifier *= 1
+				} else if utils.InRange(end, mid, threshold) {
+					modifier *= 0.8
+				} else if utils.InRange(end, start, lossThreshold) {
+					modifier *= 0.4
+				} else {
+					modifier *= 0.1
+				}
+				w.Win += modifier * weight
+			} else {
+				w.Win -= 12 * weight
+			}
+		}
+		log.Debugf("Symbol: %s, Direction: %d, Start: %.5f, End: %.5f, %v (%.5f, %.5f)",
+			s.Symbol, s.Direction, start, end, time.Duration(s.RunningTime)*time.Second, s.LowerLimit, s.UpperLimit)
+	}
+	finalizeWL(directionWL)
+	return directionWL
+}
@@ -1,12 +1,12 @@
 package gsp
 
 import (
+	"BinanceTopStrategies/clock"
 	"BinanceTopStrategies/config"
 	"BinanceTopStrategies/discord"
 	"BinanceTopStrategies/request"
 	"BinanceTopStrategies/sdk"
 	"BinanceTopStrategies/utils"
-	"encoding/json"
 	"fmt"
 	"sort"
 	"strconv"
@@ -21,6 +21,7 @@ type StrategyQuery struct {
 	RuntimeMin time.Duration
 	Symbol     string
 	Type       int
+	Source     string // groups queries in the coverage stats
 }
 
 type StrategyMetrics struct {
@@ -62,6 +63,7 @@ type Strategy struct {
 	LatestMatchedCount int            `json:"latestMatchedCount"`
 	MatchedCount       int            `json:"matchedCount"`
 	MinInvestment      string         `json:"minInvestment"`
+	GridPolicy         string         `json:"-"` // adaptation policies the grid is placed with
 }
 
 type StrategyParams struct {
@@ -118,8 +120,8 @@ func (grid *Grid) GetNormalizedRoi() float64 {
 	return grid.LastRoi / (float64(grid.GetRunTime().Seconds()) / 3600)
 }
 
-func (s *Strategy) MarketPriceWithinRange() bool {
-	marketPrice, _ := sdk.GetSessionSymbolPrice(s.Symbol)
+func (s *Strategy) MarketPriceWithinRange(prices *sdk.PriceSnapshot) bool {
+	marketPrice, _ := prices.Get(s.Symbol)
 	return marketPrice > s.StrategyParams.LowerLimit && marketPrice < s.StrategyParams.UpperLimit
 }
 
@@ -141,19 +143,6 @@ func (s *Strategy) SD() string {
 	return s.Symbol + DirectionMap[s.Direction]
 }
 
-func (s *Strategy) PopulateRois() error {
-	id := s.SID
-	rois, err := RoisCache.Get(fmt.Sprintf("%d-%d", id, s.UserID))
-	if err != nil {
-		return err
-	}
-	s.Rois = rois
-	if len(s.Rois) > 1 {
-		s.Roi = s.Rois[0].Roi
-	}
-	return nil
-}
-
 func (s *Strategy) Sanitize() {
 	s.Roi, _ = strconv.ParseFloat(s.RoiStr, 64)
 	s.Roi /= 100
@@ -165,12 +154,12 @@ func (s *Strategy) Sanitize() {
 
 func (rois StrategyRoi) isRunning() bool {
 	latestTime := time.Unix(rois[0].Time, 0)
-	return time.Now().Sub(latestTime) <= 95*time.Minute
+	return clock.Since(latestTime) <= 95*time.Minute
 }
 
-func Display(s *Strategy, grid *Grid, action string, index int, length int) string {
+func (session *Session) Display(s *Strategy, grid *Grid, action string, index int, length int) string {
 	if s != nil && len(s.Rois) == 0 {
-		err := s.PopulateRois()
+		err := session.PopulateRois(s)
 		if err != nil {
 			discord.Errorf("Error populating rois for %d: %s", s.SID, err)
 			s = nil
@@ -193,7 +182,7 @@ func Display(s *Strategy, grid *Grid, action string, index int, length int) stri
 	wl := ""
 	userPoolStrategies := ""
 	formatPriceRange := func(lower, upper, symbol, direction string) string {
-		mp, _ := sdk.GetSessionSymbolPrice(symbol)
+		mp, _ := session.Prices.Get(symbol)
 		l, _ := strconv.ParseFloat(lower, 64)
 		u, _ := strconv.ParseFloat(upper, 64)
 		diff := (u/l - 1) * 100
@@ -213,7 +202,7 @@ func Display(s *Strategy, grid *Grid, action string, index int, length int) stri
 		return fmt.Sprintf("%s", utils.ShortDur((time.Duration(rt) * time.Second).Round(time.Minute)))
 	}
 	if grid == nil {
-		marketPrice, _ = sdk.GetSessionSymbolPrice(s.Symbol)
+		marketPrice, _ = session.Prices.Get(s.Symbol)
 		direction = DirectionMap[s.Direction]
 		symbol = s.Symbol
 		strategyId = fmt.Sprintf("%d", s.SID)
@@ -222,12 +211,12 @@ func Display(s *Strategy, grid *Grid, action string, index int, length int) stri
 		priceRange = formatPriceRange(s.StrategyParams.LowerLimitStr, s.StrategyParams.UpperLimitStr, s.Symbol, DirectionMap[s.Direction])
 		grids = fmt.Sprintf("%d", s.StrategyParams.GridCount)
 	} else {
-		marketPrice, _ = sdk.GetSessionSymbolPrice(grid.Symbol)
+		marketPrice, _ = session.Prices.Get(grid.Symbol)
 		direction = grid.Direction
 		symbol = grid.Symbol
 		strategyId = fmt.Sprintf("%d", grid.SID)
 		leverage = fmt.Sprintf("%.2fX%d=%d", grid.InitialValue, grid.InitialLeverage, int(grid.InitialValue*float64(grid.InitialLeverage)))
-		runTime = formatRunTime(time.Now().Unix() - grid.BookTime/1000)
+		runTime = formatRunTime(clock.Now().Unix() - grid.BookTime/1000)
 		priceRange = formatPriceRange(grid.GridLowerLimit, grid.GridUpperLimit, grid.Symbol, grid.Direction)
 		grids = fmt.Sprintf("%d", grid.GridCount)
 		if s != nil {
@@ -244,12 +233,12 @@ func Display(s *Strategy, grid *Grid, action string, index int, length int) stri
 				grids = fmt.Sprintf("S/G: %d/%d", s.StrategyParams.GridCount, grid.GridCount)
 			}
 			leverage = fmt.Sprintf("%dX/%.2fX%d=%d", s.StrategyParams.Leverage, grid.InitialValue, grid.InitialLeverage, int(grid.InitialValue*float64(grid.InitialLeverage)))
-			runTime = fmt.Sprintf("%s/%s", formatRunTime(int64(s.RunningTime)), formatRunTime(time.Now().Unix()-grid.BookTime/1000))
+			runTime = fmt.Sprintf("%s/%s", formatRunTime(int64(s.RunningTime)), formatRunTime(clock.Now().Unix()-grid.BookTime/1000))
 			if s.StrategyParams.LowerLimitStr != grid.GridLowerLimit || s.StrategyParams.UpperLimitStr != grid.GridUpperLimit {
 				priceRange = fmt.Sprintf("S/G: %s/%s", formatPriceRange(s.StrategyParams.LowerLimitStr, s.StrategyParams.UpperLimitStr, s.Symbol, DirectionMap[s.Direction]),
 					formatPriceRange(grid.GridLowerLimit, grid.GridUpperLimit, grid.Symbol, grid.Direction))
 			}
-			userWl, err := UserWLCache.Get(fmt.Sprintf("%d", s.UserID))
+			userWl, err := session.GetUserWL(s.UserID)
 			if err == nil {
 				wl = userWl.String()
 			}
@@ -258,7 +247,11 @@ func Display(s *Strategy, grid *Grid, action string, index int, length int) stri
 	if s != nil {
 		ss = s.String()
 		userPoolStrategies = fmt.Sprintf("Pool: %d",
-			len(GetPool().ByUID()[s.UserID]))
+			len(session.Pool.ByUID()[s.UserID]))
+		performance, err := session.GetCopyPerformance(s.UserID, AllSymbols)
+		if err == nil && performance != nil {
+			userPoolStrategies += ", " + performance.String()
+		}
 	}
 	if grid != nil {
 		gg = ", " + grid.String()
@@ -301,7 +294,16 @@ var DirectionSMap = map[string]int{
 }
 
 func mergeStrategies(sps ...StrategyQuery) (Strategies, error) {
+	sss, _, err := crawlStrategies(sps...)
+	return sss, err
+}
+
+// crawlStrategies runs the queries and dedups the result, keeping the longest running record of a strategy
+func crawlStrategies(sps ...StrategyQuery) (Strategies, []*CrawlCoverage, error) {
 	sss := make(Strategies, 0)
+	coverage := make([]*CrawlCoverage, 0)
+	bySource := make(map[string]*CrawlCoverage)
+	seen := make(map[int]bool)
 	for _, sp := range sps {
 		if sp.Count == 0 {
 			sp.Count = 3000
@@ -314,7 +316,24 @@ func mergeStrategies(sps ...StrategyQuery) (Strategies, error) {
 		}
 		by, err := _getTopStrategies(sp.Sort, sp.Direction, sp.Type, sp.RuntimeMin, sp.RuntimeMax, sp.Count, sp.Symbol)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		c, ok := bySource[sp.Source]
+		if !ok {
+			c = &CrawlCoverage{Source: sp.Source}
+			bySource[sp.Source] = c
+			coverage = append(coverage, c)
+		}
+		c.Queries++
+		c.Fetched += len(by)
+		if len(by) >= sp.Count {
+			c.Capped++
+		}
+		for _, s := range by {
+			if !seen[s.SID] {
+				seen[s.SID] = true
+				c.Unique++
+			}
 		}
 		sss = append(sss, by...)
 	}
@@ -334,19 +353,11 @@ func mergeStrategies(sps ...StrategyQuery) (Strategies, error) {
 	for _, s := range sssMap {
 		sss = append(sss, s)
 	}
-	return sss, nil
+	return sss, coverage, nil
 }
 
-func getTopStrategies(sType int) (Strategies, error) {
-	var queries []StrategyQuery
-	for i := 0; i < 48; i += 2 {
-		queries = append(queries, StrategyQuery{Type: sType, Sort: SortByPnl, RuntimeMin: time.Duration(i) * time.Hour, RuntimeMax: time.Duration(i+2) * time.Hour})
-	}
-	merged, err := mergeStrategies(queries...)
-	if err != nil {
-		return nil, err
-	}
-	return merged, nil
+func getTopStrategies(sType int) (Strategies, []*CrawlCoverage, error) {
+	return crawlStrategies(crawlPlan(sType)...)
 }
 
 func DiscoverRootStrategy(sid int, symbol string, direction int, roughRuntime time.Duration) (*Strategy, error) {
@@ -381,29 +392,14 @@ func _getTopStrategies(sort string, direction *int, strategyType int, runningTim
 		Direction:      direction,
 		Symbol:         symbol,
 	}
-	strategies, res, err := request.Request(
-		"https://www.binance.com/bapi/futures/v1/public/future/common/strategy/landing-page/queryTopStrategy",
+	strategies, _, err := request.Request(
+		request.Url("/bapi/futures/v1/public/future/common/strategy/landing-page/queryTopStrategy"),
 		query, &StrategiesResponse{})
 	// this API returns different results based on if user agents or another header is passed to it
 	// if no such header is passed to it, it returns grids count min 2 (high risk)
 	if err != nil {
 		return nil, err
 	}
-	generic := map[string]interface{}{}
-	err = json.Unmarshal(res, &generic)
-	if err != nil {
-		return nil, err
-	}
-	if len(generic) != 6 {
-		discord.Infof("Error, strategies root response has length %d: %+v", len(generic),
-			generic)
-	}
-	for _, v := range generic["data"].([]interface{}) {
-		if len(v.(map[string]interface{})) != 14 {
-			discord.Infof("Error, strategy response has length %d: %+v", len(v.(map[string]interface{})),
-				v)
-		}
-	}
 	for _, strategy := range strategies.Data {
 		strategy.Sanitize()
 	}
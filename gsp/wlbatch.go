@@ -0,0 +1,176 @@
+package gsp
+
+import (
+	"BinanceTopStrategies/cache"
+	"BinanceTopStrategies/clock"
+	"BinanceTopStrategies/config"
+	"BinanceTopStrategies/discord"
+	"BinanceTopStrategies/sql"
+	"context"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+type wlDB struct {
+	UserID            int64     `db:"user_id"`
+	Direction         string    `db:"direction"`
+	Total             float64   `db:"total"`
+	TotalWL           float64   `db:"total_wl"`
+	Win               float64   `db:"win"`
+	WinRatio          float64   `db:"win_ratio"`
+	ShortRunning      float64   `db:"short_running"`
+	ShortRunningRatio float64   `db:"short_running_ratio"`
+	Earliest          time.Time `db:"earliest"`
+	TimeUpdated       time.Time `db:"time_updated"`
+	Version           int       `db:"version"`
+	Score             *float64  `db:"score"`
+	ScoreMethod       *string   `db:"score_method"`
+}
+
+var wlColumns = []string{
+	"user_id",
+	"direction",
+	"total",
+	"total_wl",
+	"win",
+	"win_ratio",
+	"short_running",
+	"short_running_ratio",
+	"earliest",
+	"time_updated",
+	"version",
+	"score",
+	"score_method",
+}
+
+// newPrecomputedWLCache holds the WL ComputeAllWL wrote to bts.wl, so trading doesn't score users itself
+func newPrecomputedWLCache() *cache.Cache[map[int]UserWL] {
+	return cache.CreateCache[map[int]UserWL]("wl_precomputed", 5*time.Minute,
+		func() (map[int]UserWL, error) {
+			rows := make([]*wlDB, 0)
+			err := sql.GetDB().Scan(&rows, `SELECT * FROM bts.wl WHERE time_updated >= $1 AND version = ANY($2) AND score_method = $3`,
+				clock.Now().Add(-time.Duration(config.TheConfig.WlPrecomputedMaxAgeMinutes)*time.Minute), wlVersions(), ScoreMethod())
+			if err != nil {
+				return nil, err
+			}
+			wls := make(map[int]UserWL)
+			for _, r := range rows {
+				user := int(r.UserID)
+				wl, ok := wls[user]
+				if !ok {
+					wl = UserWL{UserId: user, UpdatedAt: r.TimeUpdated, Versions: make(map[int]map[int]*WL)}
+				}
+				if r.TimeUpdated.Before(wl.UpdatedAt) {
+					wl.UpdatedAt = r.TimeUpdated
+				}
+				if _, ok := wl.Versions[r.Version]; !ok {
+					wl.Versions[r.Version] = newDirectionWL()
+				}
+				direction, ok := DirectionSMap[r.Direction]
+				if r.Direction == "TOTAL" {
+					direction, ok = TOTAL, true
+				}
+				if !ok {
+					continue
+				}
+				w := &WL{Id: r.Direction, Total: r.Total, TotalWL: r.TotalWL, Win: r.Win, WinRatio: r.WinRatio,
+					ShortRunning: r.ShortRunning, ShortRunningRatio: r.ShortRunningRatio, EarliestTime: r.Earliest}
+				if r.Score != nil {
+					w.Score = *r.Score
+				}
+				if r.ScoreMethod != nil {
+					w.ScoreMethod = *r.ScoreMethod
+				}
+				wl.Versions[r.Version][direction] = w
+				wls[user] = wl
+			}
+			for user, wl := range wls {
+				if _, ok := wl.Versions[config.TheConfig.WlVersion]; !ok {
+					delete(wls, user)
+					continue
+				}
+				wl.DirectionWL = wl.Versions[config.TheConfig.WlVersion]
+				wls[user] = wl
+			}
+			return wls, nil
+		})
+}
+
+// ComputeAllWL scores every user of TheChosen out of a single query and writes the result to bts.wl
+func ComputeAllWL() error {
+	t := time.Now()
+	wls, err := ComputedWL()
+	if err != nil {
+		return err
+	}
+	err = saveWLs(wls)
+	if err != nil {
+		return err
+	}
+	newPrecomputedWLCache().Invalidate() // reaches the trading process with CACHE_BACKEND=redis only, otherwise it waits for the TTL
+	discord.Infof("Computed WL of %d users, took: %v", len(wls), time.Since(t))
+	return nil
+}
+
+// ComputedWL returns the WL of every user of TheChosen without storing it
+func ComputedWL() ([]UserWL, error) {
+	strategies := make([]*UserStrategy, 0)
+	err := sql.GetDB().Scan(&strategies, userStrategiesForWLQuery("user_id IN (SELECT user_id FROM bts.TheChosen)"))
+	if err != nil {
+		return nil, err
+	}
+	byUser := make(map[int][]*UserStrategy)
+	for _, s := range strategies {
+		byUser[int(s.UserID)] = append(byUser[int(s.UserID)], s)
+	}
+	wls := make([]UserWL, 0, len(byUser))
+	for user, ss := range byUser {
+		wl, err := computeUserWL(user, ss)
+		if err != nil {
+			return nil, err
+		}
+		wls = append(wls, wl)
+	}
+	return wls, nil
+}
+
+func saveWLs(wls []UserWL) error {
+	rows := make([][]interface{}, 0)
+	for _, wl := range wls {
+		for version, directionWL := range wl.Versions {
+			for _, w := range directionWL {
+				if w.Total == 0 {
+					continue
+				}
+				rows = append(rows, []interface{}{wl.UserId, w.Id, w.Total, w.TotalWL, w.Win, w.WinRatio,
+					w.ShortRunning, w.ShortRunningRatio, w.EarliestTime, wl.UpdatedAt, version, w.Score, w.ScoreMethod})
+			}
+		}
+	}
+	return sql.SimpleTransaction(func(tx pgx.Tx) error {
+		_, err := tx.Exec(context.Background(), `CREATE TEMPORARY TABLE _temp_wl (LIKE bts.wl INCLUDING DEFAULTS) ON COMMIT DROP`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.CopyFrom(context.Background(), pgx.Identifier{"_temp_wl"}, wlColumns, pgx.CopyFromRows(rows))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(context.Background(), `INSERT INTO bts.wl (user_id, direction, total, total_wl, win, win_ratio, short_running,
+                    short_running_ratio, earliest, time_updated, version, score, score_method)
+			SELECT user_id, direction, total, total_wl, win, win_ratio, short_running,
+			       short_running_ratio, earliest, time_updated, version, score, score_method FROM _temp_wl
+			ON CONFLICT (user_id, direction, version) DO UPDATE
+			SET total = EXCLUDED.total,
+			    total_wl = EXCLUDED.total_wl,
+			    win = EXCLUDED.win,
+			    win_ratio = EXCLUDED.win_ratio,
+			    short_running = EXCLUDED.short_running,
+			    short_running_ratio = EXCLUDED.short_running_ratio,
+			    earliest = EXCLUDED.earliest,
+			    time_updated = EXCLUDED.time_updated,
+			    score = EXCLUDED.score,
+			    score_method = EXCLUDED.score_method`)
+		return err
+	})
+}
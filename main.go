@@ -1,12 +1,20 @@
 package main
 
 import (
+	"BinanceTopStrategies/account"
 	"BinanceTopStrategies/blacklist"
+	"BinanceTopStrategies/cache"
+	"BinanceTopStrategies/calendar"
 	"BinanceTopStrategies/cleanup"
+	"BinanceTopStrategies/clock"
 	"BinanceTopStrategies/config"
 	"BinanceTopStrategies/discord"
+	"BinanceTopStrategies/funding"
 	"BinanceTopStrategies/gsp"
+	"BinanceTopStrategies/multierr"
 	"BinanceTopStrategies/notional"
+	"BinanceTopStrategies/report"
+	"BinanceTopStrategies/schema"
 	"BinanceTopStrategies/sdk"
 	"BinanceTopStrategies/sql"
 	"BinanceTopStrategies/utils"
@@ -25,29 +33,29 @@ import (
 
 var scheduler = gocron.NewScheduler(time.Now().Location())
 
-func checkTakeProfits(grid *gsp.Grid, toCancel gsp.GridsToCancel) {
+func checkTakeProfits(acc *account.Account, grid *gsp.Grid, toCancel gsp.GridsToCancel) {
 	for c, gpMax := range config.TheConfig.TakeProfits {
 		gpMax = config.GetNormalized(gpMax, grid.InitialLeverage)
 		if grid.LastRoi >= gpMax {
 			gpLookBack := time.Duration(config.TheConfig.TakeProfitsMaxLookBackMinutes[c]) * time.Minute
 			gpBlock := time.Duration(config.TheConfig.TakeProfitsBlockMinutes[c]) * time.Minute
 			localLow, _ := grid.GetLocalWithin(gpLookBack)
-			if time.Since(grid.Highest.Time) > gpLookBack && localLow.Roi >= gpMax {
+			if clock.Since(grid.Highest.Time) > gpLookBack && localLow.Roi >= gpMax {
 				reason := fmt.Sprintf("max gain %.2f%%/%.2f%% (cutoff: %.2f%%), reached %s ago",
 					grid.LastRoi*100, grid.Highest.Roi*100, gpMax,
-					time.Since(grid.Highest.Time).Round(time.Second))
-				toCancel.AddGridToCancel(grid, gpMax, reason)
+					clock.Since(grid.Highest.Time).Round(time.Second))
+				toCancel.AddGridToCancel(grid, gpMax, fmt.Sprintf("%s_%d", gsp.ExitTakeProfit, c), reason)
 				if gpBlock < 0 {
-					blacklist.AddSymbol(grid.Symbol, utils.TillNextRefresh(), reason)
+					blacklist.AddSymbol(acc.BlacklistNamespace, grid.Symbol, utils.TillNextRefresh(), reason)
 				} else {
-					blacklist.AddSymbol(grid.Symbol, gpBlock, reason)
+					blacklist.AddSymbol(acc.BlacklistNamespace, grid.Symbol, gpBlock, reason)
 				}
 			}
 		}
 	}
 }
 
-func checkStopLoss(grid *gsp.Grid, toCancel gsp.GridsToCancel) {
+func checkStopLoss(acc *account.Account, grid *gsp.Grid, toCancel gsp.GridsToCancel) {
 	for c, sl := range config.TheConfig.StopLossMarkForRemoval {
 		slAt := config.TheConfig.StopLossMarkForRemovalSLAt[c]
 		if grid.LastRoi < config.GetNormalized(sl, grid.InitialLeverage) {
@@ -65,81 +73,120 @@ func checkStopLoss(grid *gsp.Grid, toCancel gsp.GridsToCancel) {
 	maxLoss := gsp.GetMaxLoss(grid.GID)
 	if maxLoss != nil && grid.LastRoi > *maxLoss {
 		reason := fmt.Sprintf("**stop loss reached**: %.2f%%", *maxLoss*100)
-		toCancel.AddGridToCancel(grid, *maxLoss, reason)
-		blacklist.AddSymbol(grid.Symbol, utils.TillNextRefresh(), reason)
+		toCancel.AddGridToCancel(grid, *maxLoss, gsp.ExitStopLoss, reason)
+		blacklist.AddSymbol(acc.BlacklistNamespace, grid.Symbol, utils.TillNextRefresh(), reason)
 	}
 }
 
-func tick() error {
-	utils.ResetTime()
-	sdk.ClearSessionSymbolPrice()
-	discord.Infof("## Run: %v", time.Now().Format("2006-01-02 15:04:05"))
-	discord.Infof("Days since cookie: %.2f", time.Since(config.TheConfig.CookieTimeParsed).Hours()/24)
-	usdt, err := sdk.GetFuture("USDT")
+func checkFunding(grid *gsp.Grid, toCancel gsp.GridsToCancel) {
+	f, err := funding.Get(grid.Symbol)
 	if err != nil {
-		return err
+		discord.Errorf("Error getting funding for %s: %v", grid.Symbol, err)
+		return
 	}
-	usdc, err := sdk.GetFuture("USDC")
-	if err != nil {
-		return err
+	paid := f.PaidRate(grid.Direction)
+	if f.TillNextFunding() > time.Duration(config.TheConfig.FundingExitMinutes)*time.Minute ||
+		paid < config.TheConfig.FundingExitRate {
+		return
 	}
-	log.Infof("USDT: %.2f, USDC: %.2f", usdt, usdc)
+	if grid.LastRoi >= 0 && grid.LastRoi < config.GetNormalized(config.TheConfig.FundingExitMaxRoi, grid.InitialLeverage) {
+		reason := fmt.Sprintf("expensive funding %.4f%% in %s, roi %.2f%%", paid*100,
+			f.TillNextFunding().Round(time.Minute), grid.LastRoi*100)
+		toCancel.AddGridToCancel(grid, 0, gsp.ExitFunding, reason)
+	}
+}
+
+// tick refreshes the pool shared by every account, then trades each account on it
+func tick(engine *gsp.Engine) error {
+	engine.StartTick()
+	discord.Infof("## Run: %v", clock.Now().Format("2006-01-02 15:04:05"))
 	poolDB := make([]*gsp.ChosenStrategyDB, 0)
-	err = sql.GetDB().Scan(&poolDB, `SELECT * FROM bts.ThePool`)
+	err := sql.GetDB().Scan(&poolDB, `SELECT * FROM bts.ThePool`)
 	if err != nil {
 		return err
 	}
-	utils.Time("Fetched the pool")
+	engine.Timer.Time("Fetched the pool")
 	users := mapset.NewSet[int64]()
 	for _, u := range poolDB {
 		users.Add(u.UserID)
 	}
 	discord.Infof("Found %d strategies and %d users", len(poolDB), users.Cardinality())
 
-	gsp.SetPool(gsp.ToStrategies(poolDB))
+	engine.SetPool(gsp.ToStrategies(poolDB))
+	engine.PrefetchPool(poolDB)
+	engine.Timer.Time("Prefetched the pool")
+
+	mErr := multierr.NewMultiErr()
+	for _, session := range engine.Sessions {
+		err := tickAccount(session)
+		if err != nil {
+			mErr.Add(fmt.Errorf("%s: %w", session.Account, err))
+		}
+	}
+	return mErr.ToError()
+}
+
+func tickAccount(session *gsp.Session) error {
+	acc := session.Account
+	discord.Infof("## Account: %s", acc)
+	discord.Infof("Days since cookie: %.2f", clock.Since(acc.CookieTimeParsed).Hours()/24)
+	usdt, err := sdk.GetFuture(acc.Futures, "USDT")
+	if err != nil {
+		return err
+	}
+	usdc, err := sdk.GetFuture(acc.Futures, "USDC")
+	if err != nil {
+		return err
+	}
+	log.Infof("USDT: %.2f, USDC: %.2f", usdt, usdc)
 
 	discord.Infof("### Current Grids:")
-	sdk.ClearSessionSymbolPrice()
-	err = gsp.UpdateOpenGrids()
+	session.RefreshPrices()
+	err = session.UpdateOpenGrids()
 	if err != nil {
 		return err
 	}
-	gsp.SessionCancelledGIDs.Clear()
+	session.CancelledGIDs.Clear()
 	toCancel := make(gsp.GridsToCancel)
 
-	utils.Time("Fetch grids")
+	session.Timer.Time("Fetch grids")
 	count := 0
-	grids := gsp.GetOpenGrids()
+	grids := session.OpenGrids()
 	for _, grid := range grids {
-		isRunning, err := gsp.IsGridOriStrategyRunning(grid)
+		count++
+		if grid.SID == 0 { // placed outside of the bot, no pending order matched it, reported by UpdateOpenGrids
+			discord.Infof(session.Display(nil, grid, "Unlinked", count, len(grids)))
+			continue
+		}
+		isRunning, err := session.IsGridOriStrategyRunning(grid)
 		if err != nil {
 			return err
 		}
-		count++
-		oriStrategy := gsp.GetPool().FindSID(grid.SID)
+		oriStrategy := session.Pool.FindSID(grid.SID)
 		if isRunning != nil {
 			if oriStrategy != nil {
 				isRunning.UserMetricsDB = oriStrategy.UserMetricsDB
 			}
 			oriStrategy = isRunning
 		}
-		discord.Infof(gsp.Display(oriStrategy, grid, "", count,
+		discord.Infof(session.Display(oriStrategy, grid, "", count,
 			len(grids)))
 		if isRunning == nil {
-			toCancel.AddGridToCancel(grid, -999, "strategy not running")
-			blacklist.AddSymbolDirection(grid.Symbol, grid.Direction, utils.TillNextRefresh(), "strategy sd not running")
+			toCancel.AddGridToCancel(grid, -999, gsp.ExitNotRunning, "strategy not running")
+			blacklist.AddSymbolDirection(acc.BlacklistNamespace, grid.Symbol, grid.Direction, utils.TillNextRefresh(), "strategy sd not running")
 		}
-		checkStopLoss(grid, toCancel)
-		checkTakeProfits(grid, toCancel)
+		checkStopLoss(acc, grid, toCancel)
+		checkTakeProfits(acc, grid, toCancel)
+		checkFunding(grid, toCancel)
 	}
 	if !toCancel.IsEmpty() {
 		discord.Infof("### Expired Strategies: %s", toCancel)
-		toCancel.CancelAll()
+		toCancel.CancelAll(session)
 	}
 
-	if toCancel.HasCancelled() && !config.TheConfig.Paper {
+	if toCancel.HasCancelled() && !acc.Paper {
 		discord.Infof("Cancelled expired grids - Skip current run")
-		gsp.SessionCancelledGIDs = toCancel.CancelledGIDs()
+		session.CancelledGIDs = toCancel.CancelledGIDs()
 		return nil
 	}
 	log.Infof("Cancel checked")
@@ -151,8 +198,8 @@ func tick() error {
 	_, _, sessionNeutrals := grids.GetLSN()
 	sortedStrategies := make(gsp.Strategies, 0)
 	log.Infof("Start to test strategies in pool")
-	for _, s := range gsp.GetPool() {
-		p, err, reason := testStrategy(s)
+	for _, s := range session.Pool {
+		p, err, reason := testStrategy(session, s)
 		if err != nil {
 			return err
 		}
@@ -164,33 +211,46 @@ func tick() error {
 			sortedStrategies = append(sortedStrategies, s)
 		}
 	}
-	sort.Slice(sortedStrategies, func(i, j int) bool {
-		iWL, _ := gsp.UserWLCache.Get(fmt.Sprintf("%d", sortedStrategies[i].UserID))
-		jWL, _ := gsp.UserWLCache.Get(fmt.Sprintf("%d", sortedStrategies[j].UserID))
-		iWLRatio := iWL.DirectionWL[sortedStrategies[i].Direction].WinRatio
-		jWLRatio := jWL.DirectionWL[sortedStrategies[j].Direction].WinRatio
-		return iWLRatio > jWLRatio
+	scores := make(map[int]float64, len(sortedStrategies))
+	for _, s := range sortedStrategies {
+		wl, _ := session.GetUserWL(s.UserID)
+		if w, ok := wl.DirectionWL[s.Direction]; ok {
+			scores[s.SID] = w.Score
+		}
+	}
+	sort.SliceStable(sortedStrategies, func(i, j int) bool {
+		return scores[sortedStrategies[i].SID] > scores[sortedStrategies[j].SID]
 	})
 	longs, shorts, neutrals := sortedStrategies.GetLSN()
 	discord.Infof("Filtered strategies: %d, %d users | L/S/N: %d, %d, %d", len(sortedStrategies),
 		sortedStrategies.Users(), longs, shorts, neutrals)
 
-	if config.TheConfig.MaxUSDTChunks-usdtChunks <= 0 &&
-		config.TheConfig.MaxUSDCChunks-usdcChunks <= 0 && !config.TheConfig.Paper {
+	if acc.MaxUSDTChunks-usdtChunks <= 0 &&
+		acc.MaxUSDCChunks-usdcChunks <= 0 && !acc.Paper {
 		discord.Infof("Max Chunks reached (%d/%d, %d/%d), No cancel - Skip current run", usdtChunks,
-			config.TheConfig.MaxUSDTChunks, usdcChunks, config.TheConfig.MaxUSDCChunks)
+			acc.MaxUSDTChunks, usdcChunks, acc.MaxUSDCChunks)
 		return nil
 	}
-	if gsp.GetPool().AllSymbols().Difference(sessionSymbols).Cardinality() == 0 &&
-		!config.TheConfig.Paper {
+	if session.Pool.AllSymbols().Difference(sessionSymbols).Cardinality() == 0 &&
+		!acc.Paper {
 		discord.Infof("All symbols exists in open grids, Skip")
 		return nil
 	}
-	if time.Now().Minute() < 19 {
-		discord.Infof("Only trade after min 19, Skip")
+	if open, reason := calendar.TheCalendar.CanTrade(gsp.LastPoolRefresh); !open {
+		discord.Infof("%s, Skip", reason)
+		return nil
+	}
+	if halted, reason := schema.Halted(); halted {
+		discord.Errorf("%s, Skip", reason)
 		return nil
 	}
 
+	candidates, err := evaluateCandidates(session, sortedStrategies, sessionSIDs, sessionSymbols)
+	if err != nil {
+		return err
+	}
+	session.Timer.Time("Evaluated candidates")
+
 	var place func(maxChunks, existingChunks int, currency, overwriteQuote string, balance float64) error
 	place = func(maxChunks, existingChunks int, currency, overwriteQuote string, balance float64) error {
 		actualCurrency := currency
@@ -200,7 +260,7 @@ func tick() error {
 		gridTotal := grids.TotalProfitByQuote(actualCurrency)
 		pnl := math.Min(gridTotal.Pnl, 0)
 		total := balance + pnl + gridTotal.Input
-		total *= 1 - config.TheConfig.Reserved
+		total *= 1 - acc.Reserved
 		chunksInt := maxChunks - existingChunks
 		chunks := float64(chunksInt)
 		if chunksInt == 0 {
@@ -208,27 +268,28 @@ func tick() error {
 			return nil
 		}
 		invChunk := balance / chunks
-		if config.TheConfig.MaxPerChunk != -1 {
-			invChunk = math.Min(balance/chunks, config.TheConfig.MaxPerChunk)
+		if acc.MaxPerChunk != -1 {
+			invChunk = math.Min(balance/chunks, acc.MaxPerChunk)
 		}
 		idealInvChunk := total / float64(maxChunks)
 		discord.Infof("### Opening %d chunks for %s %s (%.2f, %.2f):", chunksInt, currency, overwriteQuote, idealInvChunk, invChunk)
 		invChunk = math.Min(invChunk, idealInvChunk)
-		if invChunk < config.TheConfig.MinInvestmentPerChunk && !config.TheConfig.Paper {
+		if invChunk < config.TheConfig.MinInvestmentPerChunk && !acc.Paper {
 			adjusted := int(balance/config.TheConfig.MinInvestmentPerChunk) + existingChunks
 			discord.Infof("Investment too low (%f), Adjusting max chunks to %d", invChunk, adjusted)
 			return place(adjusted, existingChunks, currency, overwriteQuote, balance)
 		}
 		invChunk = float64(int(invChunk))
-		for c, s := range sortedStrategies {
+		for c, cand := range candidates {
+			s := cand.Pool
 			strategyQuote := s.Symbol[len(s.Symbol)-4:]
 			if strategyQuote != currency && strategyQuote != overwriteQuote {
 				log.Debugf("wrong quote (%s, %s), Skip", currency, strategyQuote)
 				continue
 			}
 
-			if sessionNeutrals >= config.TheConfig.MaxNeutrals && s.Direction == gsp.NEUTRAL {
-				discord.Infof("Max Neutrals reached (%d/%d), Skip", sessionNeutrals, config.TheConfig.MaxNeutrals)
+			if sessionNeutrals >= acc.MaxNeutrals && s.Direction == gsp.NEUTRAL {
+				discord.Infof("Max Neutrals reached (%d/%d), Skip", sessionNeutrals, acc.MaxNeutrals)
 				continue
 			}
 
@@ -243,135 +304,62 @@ func tick() error {
 				continue
 			}
 
-			if bl, till := blacklist.IsTradingBlocked(s.Symbol, gsp.DirectionMap[s.Direction]); bl {
+			if cand.Blacklisted {
 				blacklistedInPool.Add(s.Symbol)
-				log.Infof("Symbol blacklisted till %s, Skip", till.Format("2006-01-02 15:04:05"))
+				log.Infof(cand.Skip)
 				continue
 			}
-			userStrategies := 0
-			for _, ss := range sortedStrategies {
-				if s.UserID == ss.UserID {
-					userStrategies++
+			if cand.Skip != "" || cand.Root == nil {
+				if !cand.Quiet {
+					discord.Infof(cand.Skip)
 				}
-			}
-			if userStrategies > 4 {
-				discord.Infof("User %d already has %d strategies in sorted, Skip", s.UserID, userStrategies)
 				continue
 			}
-			userWl, err := gsp.UserWLCache.Get(fmt.Sprintf("%d", s.UserID))
-			if err != nil {
-				return err
+			s = cand.Root
+			notionalLeverage := notional.GetLeverage(s.Symbol, invChunk)
+			preferred := acc.PreferredLeverage
+			if preferred < s.StrategyParams.Leverage {
+				preferred = utils.IntMin(s.StrategyParams.Leverage, acc.MaxLeverage)
 			}
-			sInPool := s
-			s, err := gsp.DiscoverRootStrategy(s.SID, s.Symbol, s.Direction, time.Duration(s.RunningTime)*time.Second)
+			leverage := utils.IntMin(notionalLeverage, preferred)
+			// adapted is a copy, the same candidate is placed for several quotes
+			s, err := gsp.Adapt(cand.Root, cand.MarketPrice, invChunk, leverage)
 			if err != nil {
-				return err
-			}
-			if s == nil {
-				discord.Infof("Strategy candidate %d %s not running", sInPool.SID, sInPool.Symbol)
+				discord.Infof("Error adapting grid of %d: %v, Skip", cand.Root.SID, err)
 				continue
 			}
-			s.UserMetricsDB = sInPool.UserMetricsDB
-			err = s.PopulateRois()
-			if err != nil {
-				return err
+			if s.GridPolicy != gsp.PolicyCopy {
+				discord.Infof("Adapted grid (%s): %s-%s, %d grids", s.GridPolicy,
+					s.StrategyParams.LowerLimitStr, s.StrategyParams.UpperLimitStr, s.StrategyParams.GridCount)
 			}
-			if s.Roi < 0 || (len(s.Rois) > 0 && s.Rois[0].Roi < 0) {
-				continue
-			}
-
-			marketPrice, _ := sdk.GetSessionSymbolPrice(s.Symbol)
 			minInvestment, _ := strconv.ParseFloat(s.MinInvestment, 64)
-			notionalLeverage := notional.GetLeverage(s.Symbol, invChunk)
-			preferred := config.TheConfig.PreferredLeverage
-			if preferred < s.StrategyParams.Leverage {
-				preferred = utils.IntMin(s.StrategyParams.Leverage, config.TheConfig.MaxLeverage)
-			}
-			leverage := utils.IntMin(notionalLeverage, preferred)
-			gap := s.StrategyParams.UpperLimit - s.StrategyParams.LowerLimit
-			priceDiff := s.StrategyParams.UpperLimit/s.StrategyParams.LowerLimit - 1
-			minPriceDiff := 0.0
-			minWinRatio := 0.8
 			notionalMax := notional.MaxLeverage(s.Symbol)
-			requiredWlCount := 5.9
-			minInput := 998.0
-			maxRuntimeMin := 160
-			switch s.Direction {
-			case gsp.LONG:
-				if marketPrice > s.StrategyParams.UpperLimit-gap*config.TheConfig.LongRangeDiff {
-					discord.Infof("Market Price too high for long, Skip")
-					continue
-				}
-				minPriceDiff = 0.02
-			case gsp.NEUTRAL:
+			minInput := cand.MinInput
+			if s.Direction == gsp.NEUTRAL {
 				minInvestPerLeverage := minInvestment * float64(s.StrategyParams.Leverage)
 				minLeverage := int(math.Ceil(minInvestPerLeverage / invChunk))
-				if minLeverage > config.TheConfig.MaxLeverage || minLeverage > notionalMax {
+				if minLeverage > acc.MaxLeverage || minLeverage > notionalMax {
 					discord.Infof("%s Investment too low %f, Min leverage %d, Notional Max %d, Skip", s.Symbol, invChunk, minLeverage, notionalMax)
 					continue
 				} else if minLeverage > leverage {
 					leverage = minLeverage
 				}
-				minPriceDiff = 0.08
-				minWinRatio = 0.84
-				requiredWlCount = 11.9
-				minInput = 2998.0
-				maxRuntimeMin = 220
-			case gsp.SHORT:
-				if marketPrice < s.StrategyParams.LowerLimit+gap*config.TheConfig.ShortRangeDiff {
-					discord.Infof("Market Price too low for short, Skip")
-					continue
-				}
-				minPriceDiff = 0.02
 			}
 			if currency == "USDC" && overwriteQuote == "" {
 				minInput *= 0.7
 			}
-			if priceDiff < minPriceDiff {
-				discord.Infof("Price difference too low, Skip")
-				continue
-			}
-			wl := userWl.DirectionWL[s.Direction]
-			if wl.WinRatio < minWinRatio {
-				discord.Infof("Win Ratio too low %.2f/%.2f, Skip", wl.WinRatio, minWinRatio)
-				continue
-			}
-			if wl.TotalWL < requiredWlCount {
-				discord.Infof("Total WL too low %.2f/%.2f, Skip", wl.TotalWL, requiredWlCount)
-				continue
-			}
 			if s.UserInput < minInput {
 				discord.Infof("Low input %.2f/%.2f, Skip", s.UserInput, minInput)
 				continue
 			}
-			if s.RunningTime > maxRuntimeMin*60 {
-				discord.Infof("Strategy %d running for more than %d minutes, Skip", s.SID, maxRuntimeMin)
-				continue
-			}
-
-			if s.StrategyParams.TriggerPrice != nil {
-				triggerPrice, _ := strconv.ParseFloat(*s.StrategyParams.TriggerPrice, 64)
-				marketPrice, _ := sdk.GetSessionSymbolPrice(s.Symbol)
-				diff := math.Abs((triggerPrice - marketPrice) / marketPrice)
-				if diff > config.TheConfig.TriggerRangeDiff {
-					discord.Infof("Trigger Price difference too high, Skip, Trigger: %f, Market: %f, Diff: %f",
-						triggerPrice, marketPrice, diff)
-					continue
-				}
-			}
-
-			if !s.MarketPriceWithinRange() {
-				discord.Infof("Market Price not within range, Skip")
-				continue
-			}
 
 			if overwriteQuote != "" {
 				s.Symbol = utils.OverwriteQuote(s.Symbol, overwriteQuote, len(currency))
 			}
-			discord.Infof(gsp.Display(s, nil, "New", c+1, len(sortedStrategies)))
+			discord.Infof(session.Display(s, nil, "New", c+1, len(sortedStrategies)))
 		place:
-			errr := gsp.PlaceGrid(*s, invChunk, leverage, false)
-			if !config.TheConfig.Paper {
+			errr := gsp.PlaceGrid(acc, *s, invChunk, leverage, false)
+			if !acc.Paper {
 				if errr != nil {
 					discord.Infof("**Error placing grid: %v**", errr)
 					if strings.Contains(errr.Error(), "Create grid too frequently") {
@@ -379,16 +367,16 @@ func tick() error {
 						break
 					}
 					if (strings.Contains(errr.Error(), "notional") || strings.Contains(errr.Error(), "margin is below minimum")) &&
-						s.Direction != gsp.NEUTRAL && leverage < config.TheConfig.MaxLeverage && leverage < notionalMax {
+						s.Direction != gsp.NEUTRAL && leverage < acc.MaxLeverage && leverage < notionalMax {
 						leverage += 4
-						if leverage > config.TheConfig.MaxLeverage {
-							leverage = config.TheConfig.MaxLeverage
+						if leverage > acc.MaxLeverage {
+							leverage = acc.MaxLeverage
 						}
 						discord.Infof("Increase leverage to %d", leverage)
 						goto place
 					}
 				} else {
-					discord.Actionf(gsp.Display(s, nil, "**Opened Grid**", c+1, len(sortedStrategies)))
+					discord.Actionf(session.Display(s, nil, "**Opened Grid**", c+1, len(sortedStrategies)))
 					chunksInt -= 1
 					sessionSymbols.Add(s.Symbol)
 					sessionSIDs.Add(s.SID)
@@ -404,15 +392,15 @@ func tick() error {
 		return nil
 	}
 
-	err = place(config.TheConfig.MaxUSDCChunks, usdcChunks, "USDC", "", usdc)
+	err = place(acc.MaxUSDCChunks, usdcChunks, "USDC", "", usdc)
 	if err != nil {
 		return err
 	}
-	err = place(config.TheConfig.MaxUSDCChunks, usdcChunks, "USDT", "USDC", usdc)
+	err = place(acc.MaxUSDCChunks, usdcChunks, "USDT", "USDC", usdc)
 	if err != nil {
 		return err
 	}
-	err = place(config.TheConfig.MaxUSDTChunks, usdtChunks, "USDT", "", usdt)
+	err = place(acc.MaxUSDTChunks, usdtChunks, "USDT", "", usdt)
 	if err != nil {
 		return err
 	}
@@ -420,8 +408,8 @@ func tick() error {
 	if blacklistedInPool.Cardinality() > 0 {
 		discord.Infof("Blacklisted in pool: %s", blacklistedInPool)
 	}
-	utils.Time("Place/Cancel done")
-	err = gsp.UpdateOpenGrids()
+	session.Timer.Time("Place/Cancel done")
+	err = session.UpdateOpenGrids()
 	if err != nil {
 		return err
 	}
@@ -476,6 +464,10 @@ func configPop() {
 // can i push
 func main() {
 	config.Init()
+	calendar.Init()
+	if err := cache.Init(); err != nil {
+		log.Fatalf("error initializing cache: %v", err)
+	}
 	configPop()
 	blocking := make(chan bool, 1)
 	cleanup.InitSignalCallback(blocking)
@@ -486,16 +478,21 @@ func main() {
 	sdk.Init()
 	switch config.TheConfig.Mode {
 	case "trading":
-		if config.TheConfig.Paper {
-			discord.Errorf("Paper Trading")
-		} else {
-			discord.Errorf("Real Trading")
+		if err := account.Init(); err != nil {
+			log.Fatalf("error loading accounts: %v", err)
 		}
+		for _, acc := range account.Accounts {
+			if acc.Paper {
+				discord.Errorf("Paper Trading: %s", acc)
+			} else {
+				discord.Errorf("Real Trading: %s", acc)
+			}
+		}
+		engine := gsp.NewEngine(account.Accounts...)
 		panicOnErrorSec(scheduler.SingletonMode().Every(config.TheConfig.TickEverySeconds).Seconds().Do(
 			func() {
-				utils.ResetTime()
 				t := time.Now()
-				err := tick()
+				err := tick(engine)
 				if err != nil {
 					discord.Errorf("Error: %v", err)
 				}
@@ -503,6 +500,7 @@ func main() {
 			},
 		))
 	case "SQL":
+		warnUnsharedCache()
 		panicOnErrorSec(scheduler.SingletonMode().Every(3).Minutes().Do(func() {
 			t := time.Now()
 			discord.Infof("### Prices: %v", time.Now().Format("2006-01-02 15:04:05"))
@@ -519,6 +517,14 @@ func main() {
 			if err != nil {
 				discord.Errorf("TheChosen: %v", err)
 			}
+			err = gsp.ComputeAllWL()
+			if err != nil {
+				discord.Errorf("WL: %v", err)
+			}
+			err = gsp.RefreshCopyPerformance()
+			if err != nil {
+				discord.Errorf("CopyPerformance: %v", err)
+			}
 			discord.Infof("*TheChosen run took: %v*", time.Since(t))
 		}))
 		panicOnErrorSec(scheduler.SingletonMode().Cron("15,18,24,30,45 * * * *").Do(func() {
@@ -530,6 +536,43 @@ func main() {
 			}
 			discord.Infof("*Pool run took: %v*", time.Since(t))
 		}))
+		panicOnErrorSec(scheduler.SingletonMode().Every(config.TheConfig.KlineEveryMinutes).Minutes().Do(func() {
+			t := time.Now()
+			discord.Infof("### Klines: %v", time.Now().Format("2006-01-02 15:04:05"))
+			err := gsp.IngestKlines()
+			if err != nil {
+				discord.Errorf("Klines: %v", err)
+			}
+			discord.Infof("*Klines run took: %v*", time.Since(t))
+		}))
+		if config.TheConfig.FollowUpEveryMinutes > 0 {
+			panicOnErrorSec(scheduler.SingletonMode().Every(config.TheConfig.FollowUpEveryMinutes).Minutes().Do(func() {
+				err := gsp.FollowUp()
+				if err != nil {
+					discord.Errorf("Follow up: %v", err)
+				}
+			}))
+		}
+		reports := []struct {
+			period string
+			cron   string
+			length time.Duration
+		}{
+			{"daily", config.TheConfig.ReportDailyCron, 24 * time.Hour},
+			{"weekly", config.TheConfig.ReportWeeklyCron, 7 * 24 * time.Hour},
+		}
+		for _, r := range reports {
+			if r.cron == "" {
+				continue
+			}
+			r := r
+			panicOnErrorSec(scheduler.SingletonMode().Cron(r.cron).Do(func() {
+				err := report.Run(r.period, r.length)
+				if err != nil {
+					discord.Errorf("Report %s: %v", r.period, err)
+				}
+			}))
+		}
 		scheduler.StartAsync()
 		for {
 			t := time.Now()
@@ -545,6 +588,22 @@ func main() {
 			_ = gsp.Scrape(gsp.SPOT, "SPOT")
 			time.Sleep(60 * time.Second)
 		}
+	case "recompute-prices":
+		warnUnsharedCache()
+		var cursor int64
+		for {
+			var err error
+			cursor, err = gsp.RecomputePrices(cursor, config.TheConfig.PriceRecomputeBatch)
+			if err != nil {
+				panic(err)
+			}
+			if cursor == 0 {
+				break
+			}
+		}
+		discord.Infof("Recomputed prices")
+		cleanup.Stop(os.Interrupt) // flushes discord and closes the database
+		return
 	case "playground":
 		gsp.GridMarkForRemoval(1, -0.6, "test4")
 		loss := gsp.GetMaxLoss(1)
@@ -554,37 +613,34 @@ func main() {
 	<-blocking
 }
 
+// warnUnsharedCache tells the WL invalidated here won't reach the trading process before they expire
+func warnUnsharedCache() {
+	if !cache.Shared() {
+		discord.Infof("Cache backend %s is not shared, the trading process keeps its WL until they expire",
+			config.TheConfig.CacheBackend)
+	}
+}
+
 func wlInspect() {
-	utils.ResetTime()
-	var userIds []int64
-	err := sql.GetDB().Scan(&userIds, `SELECT user_id FROM bts.TheChosen`)
+	timer := utils.NewTimer()
+	wls, err := gsp.ComputedWL()
 	if err != nil {
 		panic(err)
 	}
-	utils.Time("Fetched the chosen")
-	for _, user := range userIds {
-		_, err := gsp.UserWLCache.Get(fmt.Sprintf("%d", user))
-		if err != nil {
-			panic(err)
-		}
-	}
-	LWUsers := mapset.NewSet[int64]()
-	for _, user := range userIds {
-		userWl, err := gsp.UserWLCache.Get(fmt.Sprintf("%d", user))
-		if err != nil {
-			panic(err)
-		}
+	timer.Time("Computed the chosen WL")
+	LWUsers := mapset.NewSet[int]()
+	for _, userWl := range wls {
 		wlShort := userWl.DirectionWL[gsp.SHORT]
 		wlLong := userWl.DirectionWL[gsp.LONG]
 		if (wlLong.TotalWL >= 5 && wlLong.WinRatio >= 0.74) || (wlShort.TotalWL >= 5 && wlShort.WinRatio >= 0.74) {
 			log.Info(userWl)
-			LWUsers.Add(user)
+			LWUsers.Add(userWl.UserId)
 		}
 	}
 }
 
 func timeNowHourPrecision() time.Time {
-	t := time.Now()
+	t := clock.Now()
 	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, time.Local)
 }
 
@@ -596,7 +652,7 @@ func getTestStrategy(id int) *gsp.Strategy {
 	}
 	ss := gsp.ToStrategies([]*gsp.ChosenStrategyDB{&s})
 	res := ss[0]
-	err = res.PopulateRois()
+	err = gsp.NewEngine().PopulateRois(res)
 	if err != nil {
 		panic(err)
 	}
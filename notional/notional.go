@@ -9,11 +9,14 @@ import (
 	"time"
 )
 
-var bracketsCache = cache.CreateCache[*response](20*time.Minute,
-	func() (*response, error) {
+var bracketsCache = &cache.Cache[*response]{
+	Name:     "brackets",
+	TTL:      20 * time.Minute,
+	MaxStale: time.Hour,
+	FetchMethod: func() (*response, error) {
 		return getBrackets()
 	},
-)
+}
 
 type bracket struct {
 	BracketSeq                   int     `json:"bracketSeq"`
@@ -75,7 +78,7 @@ func MaxLeverage(symbol string) int {
 }
 
 func getBrackets() (*response, error) {
-	resp, _, err := request.Request("https://www.binance.com/bapi/futures/v1/friendly/future/common/brackets",
+	resp, _, err := request.Request(request.Url("/bapi/futures/v1/friendly/future/common/brackets"),
 		"{}", &response{})
 	if err != nil {
 		return nil, err
@@ -1,33 +1,47 @@
 package main
 
 import (
+	"BinanceTopStrategies/clock"
+	"BinanceTopStrategies/config"
 	"BinanceTopStrategies/discord"
 	"BinanceTopStrategies/gsp"
 	"fmt"
 	"time"
 )
 
-func testStrategy(s *gsp.Strategy) (bool, error, string) {
+func testStrategy(session *gsp.Session, s *gsp.Strategy) (bool, error, string) {
 	if s.RunningTime > 60*220 {
 		return false, nil, "Running for more than 220 minutes (db test)"
 	}
 	if s.Roi < 0 {
 		return false, nil, "Negative RoI (db test)"
 	}
-	userWl, err := gsp.UserWLCache.Get(fmt.Sprintf("%d", s.UserID))
+	userWl, err := session.GetUserWL(s.UserID)
 	if err != nil {
 		return false, err, err.Error()
 	}
 	wl := userWl.DirectionWL[s.Direction]
-	if wl.WinRatio < 0.8 ||
-		(wl.ShortRunningRatio > 0.24 && wl.WinRatio < 0.979) ||
-		wl.TotalWL < 5 {
+	minScore := config.TheConfig.WlMinScore
+	if s.Direction == gsp.NEUTRAL {
+		minScore = config.TheConfig.WlMinScoreNeutral
+	}
+	if wl.Score < minScore ||
+		(wl.ShortRunningRatio > 0.24 && wl.WinRatio < 0.979) {
 		return false, nil, fmt.Sprintf("WL unmet %s", wl)
 	}
-	if time.Now().Sub(wl.EarliestTime) < 30*24*time.Hour {
+	if clock.Since(wl.EarliestTime) < 30*24*time.Hour {
 		return false, nil, "User has not been active for more than 30 days"
 	}
-	userStrategies := gsp.GetPool().ByUID()[s.UserID]
+	for _, symbol := range []string{gsp.AllSymbols, s.Symbol} {
+		performance, err := session.GetCopyPerformance(s.UserID, symbol)
+		if err != nil {
+			return false, err, err.Error()
+		}
+		if performance.IsLosing() {
+			return false, nil, fmt.Sprintf("Copies losing, %s", performance)
+		}
+	}
+	userStrategies := session.Pool.ByUID()[s.UserID]
 	for _, us := range userStrategies {
 		if us.Symbol == s.Symbol && us.Direction != s.Direction {
 			return false, nil, "Same symbol hedging"
@@ -36,6 +50,6 @@ func testStrategy(s *gsp.Strategy) (bool, error, string) {
 	if len(userStrategies) > 6 {
 		return false, nil, fmt.Sprintf("User %d already has %d strategies, Skip", s.UserID, len(userStrategies))
 	}
-	discord.Infof("%s | %s", gsp.Display(s, nil, "Candidate", 0, 0), wl)
+	discord.Infof("%s | %s", session.Display(s, nil, "Candidate", 0, 0), wl)
 	return true, nil, ""
 }
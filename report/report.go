@@ -0,0 +1,231 @@
+package report
+
+import (
+	"BinanceTopStrategies/clock"
+	"BinanceTopStrategies/config"
+	"BinanceTopStrategies/discord"
+	"BinanceTopStrategies/gsp"
+	"BinanceTopStrategies/sql"
+	"BinanceTopStrategies/utils"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bucket aggregates the closed grids sharing a key, e.g. a quote, a direction, a symbol or an exit rule
+type Bucket struct {
+	Key         string  `json:"key"`
+	Count       int     `json:"count"`
+	Wins        int     `json:"wins"`
+	WinRatio    float64 `json:"winRatio"`
+	Pnl         float64 `json:"pnl"`
+	RealizedPnl float64 `json:"realizedPnl"`
+	FundingFee  float64 `json:"fundingFee"`
+	Fee         float64 `json:"fee"`
+	AvgHoldTime float64 `json:"avgHoldTime"` // seconds
+}
+
+type Report struct {
+	Period      string    `json:"period"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	Total       *Bucket   `json:"total"`
+	ByQuote     []*Bucket `json:"byQuote"`
+	ByDirection []*Bucket `json:"byDirection"`
+	ByExitRule  []*Bucket `json:"byExitRule"`
+	BySymbol    []*Bucket `json:"bySymbol"` // best first
+	OpenGrids   int       `json:"openGrids"`
+	OpenAvgRoi  float64   `json:"openAvgRoi"`
+}
+
+func (b *Bucket) add(c *gsp.ClosedGrid) {
+	b.Count++
+	if c.Pnl > 0 {
+		b.Wins++
+	}
+	b.Pnl += c.Pnl
+	b.RealizedPnl += c.RealizedPnl
+	b.FundingFee += c.FundingFee
+	b.Fee += c.Fee
+	b.AvgHoldTime += float64(c.HoldTime)
+}
+
+func (b *Bucket) finalize() {
+	if b.Count == 0 {
+		return
+	}
+	b.WinRatio = float64(b.Wins) / float64(b.Count)
+	b.AvgHoldTime /= float64(b.Count)
+}
+
+func (b *Bucket) String() string {
+	return fmt.Sprintf("%s: %.2f (%d/%d, %.1f%%), Hold: %s",
+		b.Key, b.Pnl, b.Wins, b.Count, b.WinRatio*100, utils.ShortDur(time.Duration(b.AvgHoldTime)*time.Second))
+}
+
+func quoteOf(symbol string) string {
+	for _, quote := range []string{"USDT", "USDC"} {
+		if strings.HasSuffix(symbol, quote) {
+			return quote
+		}
+	}
+	return "OTHER"
+}
+
+type buckets map[string]*Bucket
+
+func (bs buckets) add(key string, c *gsp.ClosedGrid) {
+	b, ok := bs[key]
+	if !ok {
+		b = &Bucket{Key: key}
+		bs[key] = b
+	}
+	b.add(c)
+}
+
+// sorted returns the buckets by pnl, best first
+func (bs buckets) sorted() []*Bucket {
+	sorted := make([]*Bucket, 0, len(bs))
+	for _, b := range bs {
+		b.finalize()
+		sorted = append(sorted, b)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Pnl == sorted[j].Pnl {
+			return sorted[i].Key < sorted[j].Key
+		}
+		return sorted[i].Pnl > sorted[j].Pnl
+	})
+	return sorted
+}
+
+func Generate(period string, from, to time.Time) (*Report, error) {
+	closed := make([]*gsp.ClosedGrid, 0)
+	err := sql.GetDB().Scan(&closed,
+		`SELECT * FROM bts.closed_grid WHERE closed_at >= $1 AND closed_at < $2`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	r := newReport(period, from, to, closed)
+
+	// grids still open at the end of the period, from their latest snapshot
+	var open struct {
+		Count  int      `db:"count"`
+		AvgRoi *float64 `db:"avg_roi"`
+	}
+	err = sql.GetDB().ScanOne(&open,
+		`SELECT COUNT(*) AS count, AVG(g.roi) AS avg_roi
+		FROM (SELECT DISTINCT ON (gid) gid, roi, time FROM bts.grid WHERE time < $2 ORDER BY gid, time DESC) g
+		WHERE g.time >= $1 AND g.time >= $2 - INTERVAL '10 minutes'
+		  AND g.gid NOT IN (SELECT gid FROM bts.closed_grid)`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	r.OpenGrids = open.Count
+	if open.AvgRoi != nil {
+		r.OpenAvgRoi = *open.AvgRoi
+	}
+	return r, nil
+}
+
+// newReport aggregates the grids closed in the period
+func newReport(period string, from, to time.Time, closed []*gsp.ClosedGrid) *Report {
+	r := &Report{Period: period, From: from, To: to, Total: &Bucket{Key: "TOTAL"}}
+	quotes, directions, rules, symbols := make(buckets), make(buckets), make(buckets), make(buckets)
+	for _, c := range closed {
+		r.Total.add(c)
+		quotes.add(quoteOf(c.Symbol), c)
+		directions.add(c.Direction, c)
+		rules.add(c.ExitRule, c)
+		symbols.add(c.Symbol, c)
+	}
+	r.Total.finalize()
+	r.ByQuote = quotes.sorted()
+	r.ByDirection = directions.sorted()
+	r.ByExitRule = rules.sorted()
+	r.BySymbol = symbols.sorted()
+	return r
+}
+
+func (r *Report) String() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("## %s Report: %s - %s\n", strings.ToUpper(r.Period[:1])+r.Period[1:],
+		r.From.Format("2006-01-02 15:04"), r.To.Format("2006-01-02 15:04")))
+	sb.WriteString(fmt.Sprintf("**%s**\n", r.Total))
+	sb.WriteString(fmt.Sprintf("Funding: %.2f, Fees: %.2f, Open: %d (%.2f%%)\n",
+		r.Total.FundingFee, r.Total.Fee, r.OpenGrids, r.OpenAvgRoi*100))
+	section := func(title string, bs []*Bucket) {
+		sb.WriteString(fmt.Sprintf("**%s**\n", title))
+		for _, b := range bs {
+			sb.WriteString(fmt.Sprintf(" * %s\n", b))
+		}
+	}
+	section("Quote", r.ByQuote)
+	section("Direction", r.ByDirection)
+	section("Exit Rule", r.ByExitRule)
+	n := utils.IntMin(3, len(r.BySymbol))
+	section("Best", r.BySymbol[:n])
+	worst := make([]*Bucket, 0, n)
+	for i := len(r.BySymbol) - 1; i >= utils.IntMax(n, len(r.BySymbol)-n); i-- { // not already among the best
+		if r.BySymbol[i].Pnl < 0 {
+			worst = append(worst, r.BySymbol[i])
+		}
+	}
+	section("Worst", worst)
+	return sb.String()
+}
+
+func (r *Report) export() error {
+	dir := config.TheConfig.ReportDir
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%s-%s", r.Period, r.To.Format("2006-01-02")))
+	j, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(name+".json", j, 0644)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(name + ".csv")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	_ = w.Write([]string{"section", "key", "count", "wins", "win_ratio", "pnl", "realized_pnl", "funding_fee", "fee", "avg_hold_seconds"})
+	rows := func(section string, bs ...*Bucket) {
+		for _, b := range bs {
+			_ = w.Write([]string{section, b.Key, strconv.Itoa(b.Count), strconv.Itoa(b.Wins),
+				fmt.Sprintf("%.4f", b.WinRatio), fmt.Sprintf("%.4f", b.Pnl), fmt.Sprintf("%.4f", b.RealizedPnl),
+				fmt.Sprintf("%.4f", b.FundingFee), fmt.Sprintf("%.4f", b.Fee), fmt.Sprintf("%.0f", b.AvgHoldTime)})
+		}
+	}
+	rows("total", r.Total)
+	rows("quote", r.ByQuote...)
+	rows("direction", r.ByDirection...)
+	rows("exit_rule", r.ByExitRule...)
+	rows("symbol", r.BySymbol...)
+	w.Flush()
+	return w.Error()
+}
+
+// Run reports the period ending now, posts it and exports it as json and csv
+func Run(period string, length time.Duration) error {
+	to := clock.Now()
+	r, err := Generate(period, to.Add(-length), to)
+	if err != nil {
+		return err
+	}
+	discord.Actionf(r.String())
+	return r.export()
+}
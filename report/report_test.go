@@ -0,0 +1,50 @@
+package report
+
+import (
+	"BinanceTopStrategies/gsp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func closedGrid(symbol, direction, rule string, pnl float64) *gsp.ClosedGrid {
+	return &gsp.ClosedGrid{Symbol: symbol, Direction: direction, ExitRule: rule, Pnl: pnl, HoldTime: 3600}
+}
+
+func TestReport(t *testing.T) {
+	to := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	r := newReport("daily", to.Add(-24*time.Hour), to, []*gsp.ClosedGrid{
+		closedGrid("BTCUSDT", "LONG", gsp.ExitTakeProfit, 10),
+		closedGrid("BTCUSDT", "LONG", gsp.ExitStopLoss, -4),
+		closedGrid("ETHUSDC", "SHORT", gsp.ExitStopLoss, -2),
+		closedGrid("SOLUSDT", "NEUTRAL", gsp.ExitGone, 1),
+		closedGrid("XRPUSDT", "LONG", gsp.ExitStopLoss, -3),
+	})
+	if r.Total.Count != 5 || r.Total.Wins != 2 || r.Total.Pnl != 2 {
+		t.Fatalf("got total %s", r.Total)
+	}
+	keys := make([]string, 0)
+	for _, b := range r.BySymbol {
+		keys = append(keys, b.Key)
+	}
+	if got := strings.Join(keys, ","); got != "BTCUSDT,SOLUSDT,ETHUSDC,XRPUSDT" {
+		t.Fatalf("got symbols %s", got)
+	}
+	if len(r.ByQuote) != 2 || r.ByQuote[0].Key != "USDT" || r.ByQuote[0].Count != 4 {
+		t.Fatalf("got quotes %v", r.ByQuote)
+	}
+
+	s := r.String()
+	best, worst, _ := strings.Cut(s[strings.Index(s, "**Best**"):], "**Worst**")
+	for _, symbol := range []string{"BTCUSDT", "SOLUSDT", "ETHUSDC"} {
+		if !strings.Contains(best, symbol) {
+			t.Fatalf("%s missing from best:\n%s", symbol, best)
+		}
+		if strings.Contains(worst, symbol) {
+			t.Fatalf("%s both in best and worst:\n%s", symbol, s)
+		}
+	}
+	if !strings.Contains(worst, "XRPUSDT") {
+		t.Fatalf("XRPUSDT missing from worst:\n%s", worst)
+	}
+}
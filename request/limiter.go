@@ -0,0 +1,43 @@
+package request
+
+import (
+	"BinanceTopStrategies/clock"
+	"BinanceTopStrategies/config"
+	"sync"
+	"time"
+)
+
+// limiter spaces out bapi requests so concurrent callers share one budget of rate requests per second
+type limiter struct {
+	mutex sync.Mutex
+	next  time.Time
+	rate  func() float64
+	clock clock.Clock // nil for the package clock
+}
+
+var (
+	// publicLimiter is shared by scraping and roi polling
+	publicLimiter = &limiter{rate: func() float64 { return config.TheConfig.RequestsPerSecond }}
+	// privateLimiter keeps placing and cancelling from queueing behind public requests
+	privateLimiter = &limiter{rate: func() float64 { return config.TheConfig.PrivateRequestsPerSecond }}
+)
+
+func (l *limiter) Wait() {
+	rate := l.rate()
+	if rate <= 0 {
+		return
+	}
+	interval := time.Duration(float64(time.Second) / rate)
+	l.mutex.Lock()
+	now := clock.Now()
+	if l.clock != nil {
+		now = l.clock.Now()
+	}
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(interval)
+	l.mutex.Unlock()
+	time.Sleep(wait)
+}
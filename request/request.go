@@ -1,10 +1,13 @@
 package request
 
 import (
+	"BinanceTopStrategies/account"
 	"BinanceTopStrategies/config"
 	"BinanceTopStrategies/discord"
+	"BinanceTopStrategies/schema"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io"
@@ -16,6 +19,9 @@ const (
 	CreateGridTooFrequently = "90805176"
 )
 
+// ErrLoginExpired is returned by every request once the session cookie stops being accepted
+var ErrLoginExpired = errors.New("login expired")
+
 type BinanceBaseResponse struct {
 	Code          any                    `json:"code"`
 	Message       string                 `json:"message"`
@@ -46,15 +52,20 @@ func (b BinanceBaseResponse) messageDetail() map[string]interface{} {
 	return b.MessageDetail
 }
 
+// Url resolves a bapi path against the configured Binance base url
+func Url(path string) string {
+	return config.TheConfig.BinanceBaseUrl + path
+}
+
 func Request[T BinanceResponse](url string, payload any, response T) (T, []byte, error) {
-	return _request(url, "POST", 0, payload, nil, response)
+	return _request(publicLimiter, url, "POST", 0, payload, nil, response)
 }
 
-func PrivateRequest[T BinanceResponse](url, method string, payload any, response T) (T, []byte, error) {
+func PrivateRequest[T BinanceResponse](acc *account.Account, url, method string, payload any, response T) (T, []byte, error) {
 	headers := map[string]string{
 		"Clienttype":         "web",
-		"Cookie":             config.TheConfig.Cookie,
-		"Csrftoken":          config.TheConfig.CSRFToken,
+		"Cookie":             acc.Cookie,
+		"Csrftoken":          acc.CSRFToken,
 		"Accept":             "*/*",
 		"Accept-Language":    "en-US,en;q=0.9,zh-CN;q=0.8,zh;q=0.7",
 		"Sec-Ch-Ua":          "\\\"Chromium\\\";v=\\\"122\\\", \\\"Not(A:Brand\\\";v=\\\"24\\\", \\\"Google Chrome\\\";v=\\\"122\\\"",
@@ -65,10 +76,10 @@ func PrivateRequest[T BinanceResponse](url, method string, payload any, response
 		"Sec-Fetch-Site":     "same-origin",
 		"User-Agent":         "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/122.0.0.0 Safari/537.36",
 	}
-	return _request(url, method, 0, payload, headers, response)
+	return _request(privateLimiter, url, method, 0, payload, headers, response)
 }
 
-func _request[T BinanceResponse](url, method string, sleep time.Duration,
+func _request[T BinanceResponse](limiter *limiter, url, method string, sleep time.Duration,
 	payload any, headers map[string]string, response T) (T, []byte, error) {
 	var p []byte
 	var err error
@@ -97,6 +108,7 @@ func _request[T BinanceResponse](url, method string, sleep time.Duration,
 		req.Header.Set(k, v)
 	}
 
+	limiter.Wait()
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return response, nil, err
@@ -116,12 +128,13 @@ func _request[T BinanceResponse](url, method string, sleep time.Duration,
 	if response.code() == "100002001" || response.code() == "100001005" {
 		discord.Errorf("Response: %s", body)
 		discord.Infof("Error, login expired")
-		return response, body, fmt.Errorf("login expired")
+		return response, body, ErrLoginExpired
 	}
 	if !response.success() {
 		discord.Errorf("Response: %s", body)
 		discord.Infof(response.message())
 		return response, body, fmt.Errorf("error: %s", response.message())
 	}
+	schema.Check(url, body)
 	return response, body, err
 }
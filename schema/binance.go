@@ -0,0 +1,134 @@
+package schema
+
+var envelope = map[string]string{
+	"code":          Null,
+	"message":       Null,
+	"messageDetail": Null,
+	"success":       Bool,
+	"data":          Null,
+}
+
+func withEnvelope(fields map[string]string) map[string]string {
+	root := make(map[string]string)
+	for k, v := range envelope {
+		root[k] = v
+	}
+	for k, v := range fields {
+		root[k] = v
+	}
+	return root
+}
+
+func init() {
+	Register(&Schema{
+		Name:     "TopStrategies",
+		Path:     "/strategy/landing-page/queryTopStrategy",
+		Root:     withEnvelope(map[string]string{"data": Array, "total": Number}),
+		ItemPath: []string{"data"},
+		Item: map[string]string{
+			"symbol":             String,
+			"copyCount":          Number,
+			"roi":                String,
+			"pnl":                String,
+			"runningTime":        Number,
+			"strategyId":         Number,
+			"strategyType":       Number,
+			"direction":          Number,
+			"userId":             Number,
+			"strategyParams":     Object,
+			"trailingType":       String,
+			"latestMatchedCount": Number,
+			"matchedCount":       Number,
+			"minInvestment":      String,
+		},
+		Critical: []string{"symbol", "strategyId", "direction", "userId", "strategyParams", "runningTime", "roi"},
+	})
+	Register(&Schema{
+		Name:     "RoiChart",
+		Path:     "/strategy/landing-page/queryRoiChart",
+		Root:     withEnvelope(map[string]string{"data": Array}),
+		ItemPath: []string{"data"},
+		Item: map[string]string{
+			"rootUserId": Number,
+			"strategyId": Number,
+			"roi":        Number,
+			"pnl":        Number,
+			"time":       Number,
+		},
+		Critical: []string{"roi", "time"},
+	})
+	Register(&Schema{
+		Name:     "OpenGrids",
+		Path:     "/grid/query-open-grids",
+		Root:     withEnvelope(map[string]string{"data": Array}),
+		ItemPath: []string{"data"},
+		Item: map[string]string{
+			"strategyId":             Number,
+			"clientStrategyId":       String,
+			"rootUserId":             Number,
+			"strategyUserId":         Number,
+			"strategyAccountId":      Number,
+			"symbol":                 String,
+			"strategyStatus":         String,
+			"bookTime":               Number,
+			"triggerTime":            Number,
+			"updateTime":             Number,
+			"gridInitialValue":       String,
+			"initialLeverage":        Number,
+			"gridProfit":             String,
+			"direction":              String,
+			"matchedPnl":             String,
+			"unmatchedAvgPrice":      String,
+			"unmatchedQty":           String,
+			"unmatchedFee":           String,
+			"gridEntryPrice":         String,
+			"gridPosition":           String,
+			"version":                Number,
+			"copyCount":              Number,
+			"copiedStrategyId":       Number,
+			"sharing":                Bool,
+			"isSubAccount":           Bool,
+			"strategyAmount":         String,
+			"trailingUp":             Bool,
+			"trailingDown":           Bool,
+			"trailingStopUpperLimit": Bool,
+			"trailingStopLowerLimit": Bool,
+			"orderCurrency":          String,
+			"gridUpperLimit":         String,
+			"gridLowerLimit":         String,
+			"matchedCount":           Number,
+			"gridCount":              Number,
+			"perGridQuoteQty":        String,
+			"perGridQty":             String,
+			"fundingFee":             String,
+			"marginType":             String,
+		},
+		Critical: []string{"strategyId", "symbol", "direction", "gridInitialValue", "initialLeverage",
+			"gridProfit", "copiedStrategyId", "bookTime", "gridUpperLimit", "gridLowerLimit"},
+	})
+	Register(&Schema{
+		Name:     "Brackets",
+		Path:     "/future/common/brackets",
+		ItemPath: []string{"data", "brackets"},
+		Item: map[string]string{
+			"symbol":        String,
+			"updateTime":    Number,
+			"notionalLimit": Number,
+			"riskBrackets":  Array,
+		},
+		Critical: []string{"symbol", "riskBrackets"},
+	})
+	Register(&Schema{
+		Name:     "PlaceGrid",
+		Path:     "/grid/place-grid",
+		ItemPath: []string{"data"},
+		Item: map[string]string{
+			"strategyId":       Number,
+			"clientStrategyId": String,
+			"strategyType":     String,
+			"strategyStatus":   String,
+			"updateTime":       Number,
+		},
+		Critical: []string{"strategyId"},
+	})
+}
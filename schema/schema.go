@@ -0,0 +1,253 @@
+package schema
+
+import (
+	"BinanceTopStrategies/blacklist"
+	"BinanceTopStrategies/clock"
+	"BinanceTopStrategies/config"
+	"BinanceTopStrategies/discord"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	String = "string"
+	Number = "number"
+	Bool   = "bool"
+	Object = "object"
+	Array  = "array"
+	Null   = "null"
+)
+
+// Schema is the shape we expect from an endpoint, Root is the response envelope and Item
+// the object(s) found under ItemPath, Critical item fields halt trading when they are gone
+type Schema struct {
+	Name     string
+	Path     string
+	Root     map[string]string
+	ItemPath []string
+	Item     map[string]string
+	Critical []string
+}
+
+type Diff struct {
+	Missing []string
+	Unknown []string
+	Changed []string // field: expected->actual
+}
+
+func (d *Diff) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Unknown) == 0 && len(d.Changed) == 0
+}
+
+func (d *Diff) String() string {
+	parts := make([]string, 0)
+	if len(d.Missing) > 0 {
+		parts = append(parts, "Missing: "+strings.Join(d.Missing, ", "))
+	}
+	if len(d.Unknown) > 0 {
+		parts = append(parts, "Unknown: "+strings.Join(d.Unknown, ", "))
+	}
+	if len(d.Changed) > 0 {
+		parts = append(parts, "Changed: "+strings.Join(d.Changed, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+var (
+	mutex      sync.Mutex
+	registry   = make([]*Schema, 0)
+	reported   = make(map[string]time.Time)
+	halted     string
+	haltedTill time.Time
+)
+
+func haltDuration() time.Duration {
+	return time.Duration(config.TheConfig.SchemaHaltHours) * time.Hour
+}
+
+func Register(s *Schema) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	registry = append(registry, s)
+}
+
+// Halted tells if a critical field drifted within SchemaHaltHours, in this process or in another one
+// sharing the database
+func Halted() (bool, string) {
+	mutex.Lock()
+	reason, till := halted, haltedTill
+	mutex.Unlock()
+	if reason != "" && clock.Now().Before(till) {
+		return true, reason
+	}
+	return blacklist.TradingHalted()
+}
+
+func find(url string) *Schema {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, s := range registry {
+		if strings.HasSuffix(strings.SplitN(url, "?", 2)[0], s.Path) {
+			return s
+		}
+	}
+	return nil
+}
+
+func kindOf(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return String
+	case float64, json.Number:
+		return Number
+	case bool:
+		return Bool
+	case map[string]interface{}:
+		return Object
+	case []interface{}:
+		return Array
+	default:
+		return Null
+	}
+}
+
+func compare(expected map[string]string, actual map[string]interface{}, diff *Diff, seen map[string]bool) {
+	add := func(list *[]string, s string) {
+		if !seen[s] {
+			seen[s] = true
+			*list = append(*list, s)
+		}
+	}
+	for field, kind := range expected {
+		v, ok := actual[field]
+		if !ok {
+			add(&diff.Missing, field)
+			continue
+		}
+		actualKind := kindOf(v)
+		if actualKind != Null && kind != Null && actualKind != kind {
+			add(&diff.Changed, fmt.Sprintf("%s: %s->%s", field, kind, actualKind))
+		}
+	}
+	for field := range actual {
+		if _, ok := expected[field]; !ok {
+			add(&diff.Unknown, field)
+		}
+	}
+}
+
+func (s *Schema) items(root map[string]interface{}) []map[string]interface{} {
+	var current interface{} = root
+	for _, key := range s.ItemPath {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = obj[key]
+	}
+	switch v := current.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{v}
+	case []interface{}:
+		items := make([]map[string]interface{}, 0, len(v))
+		for _, i := range v {
+			if obj, ok := i.(map[string]interface{}); ok {
+				items = append(items, obj)
+			}
+		}
+		return items
+	}
+	return nil
+}
+
+// Diff compares a response body with the schema
+func (s *Schema) Diff(body []byte) (*Diff, error) {
+	root := map[string]interface{}{}
+	err := json.Unmarshal(body, &root)
+	if err != nil {
+		return nil, err
+	}
+	diff := &Diff{}
+	seen := make(map[string]bool)
+	if s.Root != nil {
+		compare(s.Root, root, diff, seen)
+	}
+	if s.Item != nil {
+		for _, item := range s.items(root) {
+			compare(s.Item, item, diff, seen)
+		}
+	}
+	sort.Strings(diff.Missing)
+	sort.Strings(diff.Unknown)
+	sort.Strings(diff.Changed)
+	return diff, nil
+}
+
+func (s *Schema) critical(diff *Diff) []string {
+	broken := make([]string, 0)
+	for _, c := range s.Critical {
+		for _, m := range diff.Missing {
+			if m == c {
+				broken = append(broken, c)
+			}
+		}
+		for _, ch := range diff.Changed {
+			if strings.HasPrefix(ch, c+":") {
+				broken = append(broken, c)
+			}
+		}
+	}
+	return broken
+}
+
+// Check compares the response of url with its schema, a drift is reported once per SchemaHaltHours with
+// a sample archived, and trading is halted for as long when a critical field is gone
+func Check(url string, body []byte) {
+	s := find(url)
+	if s == nil {
+		return
+	}
+	diff, err := s.Diff(body)
+	if err != nil || diff.Empty() {
+		return
+	}
+	key := s.Name + diff.String()
+	mutex.Lock()
+	if at, ok := reported[key]; ok && clock.Since(at) < haltDuration() {
+		mutex.Unlock()
+		return
+	}
+	reported[key] = clock.Now()
+	mutex.Unlock()
+
+	discord.Errorf("Schema drift %s: %s", s.Name, diff)
+	archive(s.Name, body)
+	if broken := s.critical(diff); len(broken) > 0 {
+		reason := fmt.Sprintf("Schema drift %s, critical fields: %s", s.Name, strings.Join(broken, ", "))
+		mutex.Lock()
+		halted = reason
+		haltedTill = clock.Now().Add(haltDuration())
+		mutex.Unlock()
+		blacklist.HaltTrading(haltDuration(), reason)
+		discord.Errorf("**Trading halted**, %s", reason)
+	}
+}
+
+func archive(name string, body []byte) {
+	dir := config.TheConfig.SchemaSampleDir
+	err := os.MkdirAll(dir, 0755)
+	if err == nil {
+		file := filepath.Join(dir, fmt.Sprintf("%s-%s.json", name, clock.Now().Format("20060102-150405")))
+		err = os.WriteFile(file, body, 0644)
+	}
+	if err != nil {
+		log.Errorf("Error archiving %s sample: %v", name, err)
+	}
+}
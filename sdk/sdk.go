@@ -9,28 +9,52 @@ import (
 	log "github.com/sirupsen/logrus"
 	"math"
 	"strconv"
+	"sync"
 )
 
 var FuturesClient *futures.Client
-var sessionSymbolPrice = make(map[string]float64)
 
-func ClearSessionSymbolPrice() {
-	sessionSymbolPrice = make(map[string]float64)
+// PriceSnapshot fetches the market price of a symbol once and serves it for the rest of a tick
+type PriceSnapshot struct {
+	mu     sync.Mutex
+	prices map[string]float64
 }
 
-func GetSessionSymbolPrice(symbol string) (float64, error) {
-	if _, ok := sessionSymbolPrice[symbol]; !ok {
-		marketPrice, err := fetchMarketPrice(symbol)
-		if err != nil {
-			return 0, err
-		}
-		sessionSymbolPrice[symbol] = marketPrice
+func NewPriceSnapshot() *PriceSnapshot {
+	return &PriceSnapshot{prices: make(map[string]float64)}
+}
+
+func (p *PriceSnapshot) Get(symbol string) (float64, error) {
+	p.mu.Lock()
+	price, ok := p.prices[symbol]
+	p.mu.Unlock()
+	if ok {
+		return price, nil
+	}
+	price, err := fetchMarketPrice(symbol)
+	if err != nil {
+		return 0, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cached, ok := p.prices[symbol]; ok {
+		return cached, nil
 	}
-	return sessionSymbolPrice[symbol], nil
+	p.prices[symbol] = price
+	return price, nil
 }
 
 func Init() {
-	FuturesClient = binance.NewFuturesClient(config.TheConfig.ApiKey, config.TheConfig.SecretKey) // USDT-M Futures
+	FuturesClient = NewFuturesClient(config.TheConfig.ApiKey, config.TheConfig.SecretKey)
+}
+
+// NewFuturesClient creates a USDT-M Futures client for an account
+func NewFuturesClient(apiKey, secretKey string) *futures.Client {
+	client := binance.NewFuturesClient(apiKey, secretKey)
+	if config.TheConfig.FuturesBaseUrl != "" {
+		client.BaseURL = config.TheConfig.FuturesBaseUrl
+	}
+	return client
 }
 
 func fetchMarketPrice(symbol string) (float64, error) {
@@ -46,8 +70,8 @@ func fetchMarketPrice(symbol string) (float64, error) {
 	return 0, fmt.Errorf("symbol not found")
 }
 
-func GetFuture(currency string) (float64, error) {
-	res, err := FuturesClient.NewGetBalanceService().Do(context.Background())
+func GetFuture(client *futures.Client, currency string) (float64, error) {
+	res, err := client.NewGetBalanceService().Do(context.Background())
 	if err != nil {
 		return 0, err
 	}
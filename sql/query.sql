@@ -142,33 +142,6 @@ WHERE (s.concluded = FALSE OR s.concluded IS NULL)
   AND rois_fetched_at <= NOW() - INTERVAL '45 minutes';
 
 
-DROP VIEW ToPopulate;
-
-CREATE OR REPLACE VIEW ToPopulate AS
-WITH ACTIVE AS (SELECT *
-                FROM bts.strategy s
-                WHERE (s.concluded = FALSE OR s.concluded IS NULL)
-                  AND strategy_type = 2
-                  AND NOT (
-                    EXTRACT(HOUR FROM rois_fetched_at) = EXTRACT(HOUR FROM NOW())
-                        AND EXTRACT(MINUTE FROM rois_fetched_at) > 30
-                        AND rois_fetched_at::date = NOW()::date
-                    )
-                  AND rois_fetched_at <= NOW() - INTERVAL '5 minutes'),
-     LatestRoi AS (SELECT l.strategy_id,
-                          l.roi                                                             as roi,
-                          l.pnl,
-                          l.time,
-                          ROW_NUMBER() OVER (PARTITION BY l.strategy_id ORDER BY time DESC) AS rn
-                   FROM bts.roi l)
-SELECT a.*
-FROM ACTIVE a
-         LEFT JOIN
-     LatestRoi l ON l.strategy_id = a.strategy_id
-WHERE ((l.rn = 1 AND NOW() > l.time + interval '70m')
-    OR l.rn IS NULL)
-ORDER BY l.pnl / NULLIF(l.roi, 0) desc;
-
 SELECT COUNT(*)
 FROM strategy WHERE concluded = TRUE and high_price IS NULL and strategy_type=2;
 
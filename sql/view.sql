@@ -122,8 +122,54 @@ WHERE f.original_input > 998
 ORDER BY p.total_roi DESC, f.original_input DESC;
 
 
+CREATE MATERIALIZED VIEW CopyPerformance AS
+WITH GridSnapshots AS (SELECT gid,
+                              roi,
+                              time,
+                              MIN(time) OVER (PARTITION BY gid)                        AS opened_at,
+                              ROW_NUMBER() OVER (PARTITION BY gid ORDER BY time DESC) AS rn
+                       FROM bts.grid),
+     ClosedGrids AS (SELECT c.gid,
+                            c.roi       AS final_roi,
+                            c.closed_at,
+                            c.hold_time,
+                            c.exit_rule AS exit_reason
+                     FROM bts.closed_grid c
+                     UNION ALL
+                     -- grids closed before bts.closed_grid existed, a grid whose snapshots only stopped
+                     -- later may still be open while the trading process is down, every grid counts while
+                     -- bts.closed_grid is still empty
+                     SELECT g.gid,
+                            g.roi                                      AS final_roi,
+                            g.time                                     AS closed_at,
+                            EXTRACT(EPOCH FROM (g.time - g.opened_at)) AS hold_time,
+                            COALESCE(f.reason_loss, 'unknown')         AS exit_reason
+                     FROM GridSnapshots g
+                              LEFT JOIN bts.for_removal f ON f.gid = g.gid
+                     WHERE g.rn = 1
+                       AND g.time < COALESCE((SELECT MIN(closed_at) FROM bts.closed_grid), 'infinity')
+                       AND g.gid NOT IN (SELECT gid FROM bts.closed_grid))
+SELECT s.user_id,
+       CASE WHEN GROUPING(s.symbol) = 1 THEN 'ALL' ELSE s.symbol END    AS symbol,
+       COUNT(*)                                                         AS copies,
+       COUNT(*) FILTER (WHERE c.final_roi > 0)                          AS wins,
+       AVG(c.final_roi)                                                 AS avg_roi,
+       SUM(c.final_roi)                                                 AS total_roi,
+       AVG(c.hold_time)                                                 AS avg_hold_time,
+       MAX(c.closed_at)                                                 AS last_closed_at,
+       (ARRAY_AGG(c.exit_reason ORDER BY c.closed_at DESC))[1]          AS last_exit_reason
+FROM ClosedGrids c
+         JOIN bts.grid_strategy gs ON gs.grid_id = c.gid
+         JOIN bts.strategy s ON s.strategy_id = gs.strategy_id
+GROUP BY GROUPING SETS ((s.user_id, s.symbol), (s.user_id));
+
+
 SELECT COUNT(*)
 FROM TheChosen;
 
+-- PopulateRoi schedules through bts.roi_poll
+DROP VIEW IF EXISTS ToPopulate;
+
+DROP MATERIALIZED VIEW CopyPerformance;
 DROP MATERIALIZED VIEW ThePool;
 DROP MATERIALIZED VIEW TheChosen;
\ No newline at end of file
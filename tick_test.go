@@ -0,0 +1,390 @@
+package main
+
+import (
+	"BinanceTopStrategies/account"
+	"BinanceTopStrategies/cache"
+	"BinanceTopStrategies/calendar"
+	"BinanceTopStrategies/clock"
+	"BinanceTopStrategies/config"
+	"BinanceTopStrategies/fake"
+	"BinanceTopStrategies/gsp"
+	"BinanceTopStrategies/sdk"
+	"BinanceTopStrategies/sql"
+	"context"
+	"fmt"
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	"github.com/jackc/pgx/v5"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// the e2e tests trade on a fresh bts schema of the database at BTS_TEST_PGURL, an embedded postgres is started
+// when it is not set
+const testPGUrlEnv = "BTS_TEST_PGURL"
+
+const (
+	testSID    = 1
+	testUser   = 11
+	testSymbol = "BTCUSDT"
+)
+
+var (
+	sqlInit sync.Once
+	// noDBReason is why the e2e tests have no database, empty when they have one
+	noDBReason string
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+// runTests runs m against BTS_TEST_PGURL, or against an embedded postgres that lives as long as the run
+func runTests(m *testing.M) int {
+	if os.Getenv(testPGUrlEnv) != "" {
+		return m.Run()
+	}
+	if os.Geteuid() == 0 {
+		noDBReason = fmt.Sprintf("postgres refuses to run as root, set %s or run test.sh", testPGUrlEnv)
+		return m.Run()
+	}
+	dir, err := os.MkdirTemp("", "bts-test-pg")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	port, err := freePort()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	pgConfig := embeddedpostgres.DefaultConfig().Port(port).RuntimePath(dir).Logger(io.Discard)
+	db := embeddedpostgres.NewDatabase(pgConfig)
+	if err := db.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error starting embedded postgres: %v\n", err)
+		return 1
+	}
+	defer db.Stop()
+	os.Setenv(testPGUrlEnv, pgConfig.GetConnectionURL())
+	return m.Run()
+}
+
+func freePort() (uint32, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return uint32(l.Addr().(*net.TCPAddr).Port), nil
+}
+
+// tickTest is one account trading a single long strategy against the fake server
+type tickTest struct {
+	t      *testing.T
+	server *fake.Server
+	clock  *clock.Fake
+	engine *gsp.Engine
+}
+
+func newTickTest(t *testing.T) *tickTest {
+	if noDBReason != "" {
+		t.Skip(noDBReason)
+	}
+	pgUrl := os.Getenv(testPGUrlEnv)
+	config.Init()
+	config.TheConfig.PGUrl = pgUrl
+	config.TheConfig.Accounts = nil
+	config.TheConfig.Paper = false
+	config.TheConfig.MaxUSDTChunks = 1
+	config.TheConfig.MaxUSDCChunks = 0
+	config.TheConfig.TradingMinuteWindows = []string{"0-59"}
+	config.TheConfig.TradingBlackoutHours = nil
+	config.TheConfig.TradingWeekdays = nil
+	config.TheConfig.WlPrecomputedMaxAgeMinutes = 24 * 60
+	config.TheConfig.SchemaSampleDir = t.TempDir()
+	config.TheConfig.RequestsPerSecond = 0
+	config.TheConfig.PrivateRequestsPerSecond = 0
+	config.TheConfig.CacheTTLJitter = 0
+	calendar.Init()
+	cache.SetBackend(cache.NewMemory(config.TheConfig.CacheMemorySize, nil))
+
+	c := clock.NewFake(time.Now().Truncate(time.Second))
+	clock.Set(c)
+	t.Cleanup(func() { clock.Set(clock.Real{}) })
+
+	scenario := fake.NewScenario()
+	scenario.Brackets = []*fake.SymbolBrackets{{Symbol: testSymbol, NotionalLimit: 10000000,
+		RiskBrackets: []*fake.Bracket{{BracketSeq: 1, BracketNotionalCap: 10000000,
+			MinOpenPosLeverage: 1, MaxOpenPosLeverage: 125}}}}
+	scenario.SetPrice(testSymbol, 100)
+	scenario.SetBalance("USDT", 10000)
+	scenario.AddStrategy(&fake.Strategy{
+		Symbol: testSymbol, Roi: "5", Pnl: "100", RunningTime: 3600, StrategyID: testSID,
+		StrategyType: gsp.FUTURE, Direction: gsp.LONG, UserID: testUser, TrailingType: "NONE", MinInvestment: "10",
+		StrategyParams: fake.StrategyParams{Type: "ARITHMETIC", LowerLimit: "90", UpperLimit: "110",
+			GridCount: 10, Leverage: 20},
+	},
+		&fake.Roi{RootUserID: testUser, StrategyID: testSID, Roi: 0.04, Pnl: 80, Time: c.Now().Add(-10 * time.Minute).UnixMilli()},
+		&fake.Roi{RootUserID: testUser, StrategyID: testSID, Roi: 0.05, Pnl: 100, Time: c.Now().Add(-5 * time.Minute).UnixMilli()})
+	server := fake.NewServer(scenario)
+	t.Cleanup(server.Close)
+	config.TheConfig.BinanceBaseUrl = server.URL
+	config.TheConfig.FuturesBaseUrl = server.URL
+	sdk.Init()
+
+	var err error
+	sqlInit.Do(func() { err = sql.Init() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	resetSchema(t, pgUrl)
+	insertFixtures(t)
+	if err := account.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return &tickTest{t: t, server: server, clock: c, engine: gsp.NewEngine(account.Accounts...)}
+}
+
+// resetSchema recreates bts from the tables and views of sql/, ThePool becomes a table the fixtures are written to
+func resetSchema(t *testing.T, pgUrl string) {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, pgUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+	statements := []string{`DROP SCHEMA IF EXISTS bts CASCADE`, `CREATE SCHEMA bts`, `SET search_path TO bts`}
+	for _, s := range sqlStatements(t, "sql/sql.ddl") {
+		switch {
+		case strings.HasPrefix(s, "CREATE "):
+			statements = append(statements, s)
+		case strings.HasPrefix(s, "ALTER TABLE") && strings.Contains(s, "ADD COLUMN"):
+			statements = append(statements, strings.Replace(s, "ADD COLUMN", "ADD COLUMN IF NOT EXISTS", 1))
+		}
+	}
+	for _, s := range sqlStatements(t, "sql/view.sql") {
+		if strings.HasPrefix(s, "CREATE MATERIALIZED VIEW") {
+			statements = append(statements, s)
+		}
+	}
+	statements = append(statements,
+		`CREATE TABLE pool_fixture AS SELECT * FROM ThePool WITH NO DATA`,
+		`DROP MATERIALIZED VIEW ThePool`,
+		`ALTER TABLE pool_fixture RENAME TO ThePool`)
+	for _, s := range statements {
+		if _, err := conn.Exec(ctx, s); err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+	}
+}
+
+// sqlStatements splits a sql file into its statements, without the comment lines
+func sqlStatements(t *testing.T, file string) []string {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make([]string, 0)
+	for _, line := range strings.Split(string(content), "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+	statements := make([]string, 0)
+	for _, s := range strings.Split(strings.Join(lines, "\n"), ";") {
+		if s = strings.TrimSpace(s); s != "" {
+			statements = append(statements, s)
+		}
+	}
+	return statements
+}
+
+// insertFixtures puts the strategy in a freshly refreshed pool and gives its user a precomputed WL good enough to copy
+func insertFixtures(t *testing.T) {
+	ctx := context.Background()
+	now := clock.Now()
+	_, err := sql.GetDB().Exec(ctx, `INSERT INTO bts.ThePool (roi, pnl, original_input, running_time, strategy_count,
+		total_roi, total_original_input, symbol, copy_count, strategy_id, strategy_type, direction, time_discovered,
+		user_id, rois_fetched_at, type, lower_limit, upper_limit, grid_count, base_asset, quote_asset, leverage,
+		trailing_down, trailing_up, trailing_type, latest_matched_count, matched_count, min_investment, concluded)
+		VALUES (0.05, 100, 2000, 3600, 12, 0.05, 24000, $1, 0, $2, $3, $4, $5, $6, $5, 'ARITHMETIC', 90, 110, 10,
+		        'BTC', 'USDT', 20, false, false, 'NONE', 0, 0, 10, false)`,
+		testSymbol, testSID, gsp.FUTURE, gsp.LONG, now, testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sql.GetDB().Exec(ctx, `INSERT INTO bts.refresh (name, time) VALUES ('ThePool', $1)`, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, direction := range []string{"TOTAL", "LONG"} {
+		_, err = sql.GetDB().Exec(ctx, `INSERT INTO bts.wl (user_id, direction, total, total_wl, win, win_ratio,
+			short_running, short_running_ratio, earliest, time_updated, version, score, score_method)
+			VALUES ($1, $2, 20, 20, 20, 1, 0, 0, $3, $4, $5, 1, $6)`,
+			testUser, direction, now.Add(-60*24*time.Hour), now, config.TheConfig.WlVersion, gsp.ScoreMethod())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (tt *tickTest) tick() {
+	tt.t.Helper()
+	if err := tick(tt.engine); err != nil {
+		tt.t.Fatal(err)
+	}
+}
+
+// open ticks once and returns the grid that was placed
+func (tt *tickTest) open() *fake.Grid {
+	tt.t.Helper()
+	tt.tick()
+	if len(tt.server.Scenario.OpenGrids) != 1 {
+		tt.t.Fatalf("got %d open grids, want 1", len(tt.server.Scenario.OpenGrids))
+	}
+	return tt.server.Scenario.OpenGrids[0]
+}
+
+// setRoi moves the profit of grid so that its roi on the margin is roi
+func (tt *tickTest) setRoi(grid *fake.Grid, roi float64) {
+	initial, _ := strconv.ParseFloat(grid.GridInitialValue, 64)
+	tt.server.Scenario.SetGridPnl(grid.StrategyID, roi*initial/float64(grid.InitialLeverage))
+}
+
+func (tt *tickTest) exitRule(gid int) string {
+	tt.t.Helper()
+	var rule string
+	err := sql.GetDB().ScanOne(&rule, `SELECT exit_rule FROM bts.closed_grid WHERE gid = $1`, gid)
+	if err != nil {
+		tt.t.Fatalf("closed grid %d: %v", gid, err)
+	}
+	return rule
+}
+
+func (tt *tickTest) assertClosed(gid int, rule string) {
+	tt.t.Helper()
+	closed := tt.server.Scenario.Closed
+	if len(closed) != 1 || closed[0] != gid {
+		tt.t.Fatalf("got closed %v, want [%d]", closed, gid)
+	}
+	if got := tt.exitRule(gid); got != rule {
+		tt.t.Fatalf("got exit rule %s, want %s", got, rule)
+	}
+}
+
+func TestTickOpen(t *testing.T) {
+	tt := newTickTest(t)
+	grid := tt.open()
+	if len(tt.server.Scenario.Placed) != 1 {
+		t.Fatalf("placed %d grids, want 1", len(tt.server.Scenario.Placed))
+	}
+	if grid.Symbol != testSymbol || grid.Direction != "LONG" || grid.InitialLeverage != 20 {
+		t.Fatalf("got %s %s x%d, want %s LONG x20", grid.Symbol, grid.Direction, grid.InitialLeverage, testSymbol)
+	}
+	var sid int
+	err := sql.GetDB().ScanOne(&sid, `SELECT strategy_id FROM bts.grid_strategy WHERE grid_id = $1`, grid.StrategyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sid != testSID {
+		t.Fatalf("grid linked to %d, want %d", sid, testSID)
+	}
+
+	tt.clock.Advance(time.Minute)
+	tt.tick()
+	if len(tt.server.Scenario.Placed) != 1 {
+		t.Fatalf("placed %d grids with no chunk left, want 1", len(tt.server.Scenario.Placed))
+	}
+}
+
+func TestTickTakeProfit(t *testing.T) {
+	tt := newTickTest(t)
+	grid := tt.open()
+	tt.clock.Advance(time.Minute)
+	tt.setRoi(grid, 0.13)
+	tt.tick()
+	if len(tt.server.Scenario.Closed) != 0 {
+		t.Fatal("closed at the high, want to wait out the look back")
+	}
+
+	// the last take profit holds above 10% for its 90 minutes look back
+	tt.clock.Advance(91 * time.Minute)
+	tt.setRoi(grid, 0.12)
+	tt.tick()
+	tt.assertClosed(grid.StrategyID, gsp.ExitTakeProfit+"_5")
+}
+
+func TestTickStopLoss(t *testing.T) {
+	tt := newTickTest(t)
+	grid := tt.open()
+	tt.clock.Advance(time.Minute)
+	tt.setRoi(grid, -0.45)
+	tt.tick()
+	maxLoss := gsp.GetMaxLoss(grid.StrategyID)
+	if maxLoss == nil || *maxLoss != 0 {
+		t.Fatalf("got max loss %v, want 0", maxLoss)
+	}
+	if len(tt.server.Scenario.Closed) != 0 {
+		t.Fatal("closed below the max loss")
+	}
+
+	tt.clock.Advance(time.Minute)
+	tt.setRoi(grid, 0.01)
+	tt.tick()
+	tt.assertClosed(grid.StrategyID, gsp.ExitStopLoss)
+}
+
+func TestTickGoneGrid(t *testing.T) {
+	tt := newTickTest(t)
+	grid := tt.open()
+	tt.server.Scenario.GoneGrid(grid.StrategyID)
+	tt.clock.Advance(time.Minute)
+	tt.tick()
+	if got := tt.exitRule(grid.StrategyID); got != gsp.ExitGone {
+		t.Fatalf("got exit rule %s, want %s", got, gsp.ExitGone)
+	}
+	if len(tt.server.Scenario.Closed) != 0 {
+		t.Fatalf("closed %v, the grid was already gone", tt.server.Scenario.Closed)
+	}
+}
+
+func TestTickLoginExpired(t *testing.T) {
+	tt := newTickTest(t)
+	tt.server.Scenario.FailNext(fake.OpenGridsPath, fake.LoginExpired)
+	err := tick(tt.engine)
+	if err == nil || !strings.Contains(err.Error(), "login expired") {
+		t.Fatalf("got %v, want login expired", err)
+	}
+	if len(tt.server.Scenario.Placed) != 0 {
+		t.Fatal("placed a grid with an expired login")
+	}
+
+	tt.clock.Advance(time.Minute)
+	tt.open()
+}
+
+func TestTickTooFrequent(t *testing.T) {
+	tt := newTickTest(t)
+	tt.server.Scenario.FailNext(fake.PlaceGridPath, fake.TooFrequent)
+	tt.tick()
+	if len(tt.server.Scenario.Placed) != 0 || len(tt.server.Scenario.OpenGrids) != 0 {
+		t.Fatal("placed a grid on a too frequent error")
+	}
+	var status string
+	err := sql.GetDB().ScanOne(&status, `SELECT status FROM bts.pending_order WHERE strategy_id = $1`, testSID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != gsp.FailedStatus {
+		t.Fatalf("got pending order %s, want %s", status, gsp.FailedStatus)
+	}
+
+	tt.clock.Advance(time.Minute)
+	tt.open()
+}
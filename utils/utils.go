@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"BinanceTopStrategies/calendar"
 	"BinanceTopStrategies/discord"
 	"encoding/json"
 	"fmt"
@@ -40,8 +41,7 @@ func MapValues[T comparable, U any](m map[T]U) []U {
 }
 
 func TillNextRefresh() time.Duration {
-	minutesTillNextHour := 60 - time.Now().Minute()
-	return time.Duration(minutesTillNextHour+19) * time.Minute
+	return calendar.TheCalendar.TillNextOpen()
 }
 
 func IntMin(a, b int) int {
@@ -58,15 +58,22 @@ func IntMax(a, b int) int {
 	return b
 }
 
-var timing = time.Now()
+// Timer reports how long each step of a run took since the previous one
+type Timer struct {
+	last time.Time
+}
+
+func NewTimer() *Timer {
+	return &Timer{last: time.Now()}
+}
 
-func Time(s string) {
-	discord.Infof("*%s took: %v*", s, time.Since(timing))
-	timing = time.Now()
+func (t *Timer) Time(s string) {
+	discord.Infof("*%s took: %v*", s, time.Since(t.last))
+	t.last = time.Now()
 }
 
-func ResetTime() {
-	timing = time.Now()
+func (t *Timer) Reset() {
+	t.last = time.Now()
 }
 
 func IntPointer(i int) *int {